@@ -0,0 +1,105 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/auth"
+	"github.com/Meha555/pulse/server/job"
+)
+
+// 等待认证完成的超时时间
+const kDefaultAuthTimeout = 10 * time.Second
+
+var ErrAuthFailed = errors.New("authentication failed")
+
+// Credentials 客户端的认证方式，需要与服务端配置的 auth.Authenticator 对应。
+// exchange向服务端发送一条认证消息并返回服务端的回复，最后一次exchange的回复即认证结果
+type Credentials func(exchange func(data []byte) ([]byte, error)) error
+
+// TokenCredentials 令牌认证，对应 auth.Token
+func TokenCredentials(token string) Credentials {
+	return func(exchange func([]byte) ([]byte, error)) error {
+		_, err := exchange([]byte(token))
+		return err
+	}
+}
+
+// HMACCredentials 挑战-应答认证，对应 auth.HMAC
+func HMACCredentials(userID string, secret []byte) Credentials {
+	return func(exchange func([]byte) ([]byte, error)) error {
+		challenge, err := exchange([]byte(userID))
+		if err != nil {
+			return err
+		}
+		_, err = exchange(auth.Sign(secret, challenge))
+		return err
+	}
+}
+
+// CertCredentials TLS客户端证书认证，对应 auth.TLSCert，需要同时使用 WithTLS 并在其中配置客户端证书
+func CertCredentials() Credentials {
+	return func(exchange func([]byte) ([]byte, error)) error {
+		_, err := exchange(nil)
+		return err
+	}
+}
+
+// WithCredentials 设置认证方式，每次建立连接（包括重连）后都会先完成认证
+func WithCredentials(creds Credentials) ClientOptions {
+	return func(cli *Client) {
+		cli.credentials = creds
+	}
+}
+
+// WithTLS 使用TLS连接服务端
+func WithTLS(cfg *tls.Config) ClientOptions {
+	return func(cli *Client) {
+		cli.tlsConfig = cfg
+	}
+}
+
+// UserID 返回认证通过后服务端确认的用户ID，未认证时为空
+func (c *Client) UserID() string {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.userID
+}
+
+// authenticate 在新连接上同步完成认证，返回服务端确认的用户ID
+func (c *Client) authenticate(conn net.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(kDefaultAuthTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var reply []byte
+	exchange := func(data []byte) ([]byte, error) {
+		out, err := message.Marshal(message.NewSeqedTLVMsg(0, job.AuthTag, data))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(out); err != nil {
+			return nil, fmt.Errorf("send auth msg error: %w", err)
+		}
+		msg := &message.SeqedTLVMsg{}
+		if err := readMsg(conn, msg); err != nil {
+			return nil, fmt.Errorf("recv auth msg error: %w", err)
+		}
+		switch msg.Tag() {
+		case job.AuthTag:
+			reply = msg.Body()
+			return reply, nil
+		case job.ErrorTag:
+			return nil, fmt.Errorf("%w: %s", ErrAuthFailed, msg.Body())
+		default:
+			return nil, fmt.Errorf("%w: unexpected tag[%d]", ErrAuthFailed, msg.Tag())
+		}
+	}
+	if err := c.credentials(exchange); err != nil {
+		return "", err
+	}
+	return string(reply), nil
+}
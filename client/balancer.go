@@ -0,0 +1,65 @@
+package client
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// Balancer 从可用节点中选择处理调用的节点，nodes不为空。
+// key为 Cluster.CallKey 传入的键，不关心键的策略可以忽略它
+type Balancer interface {
+	Pick(nodes []*Node, key string) *Node
+}
+
+type BalancerFunc func(nodes []*Node, key string) *Node
+
+func (f BalancerFunc) Pick(nodes []*Node, key string) *Node {
+	return f(nodes, key)
+}
+
+// RoundRobin 轮询
+func RoundRobin() Balancer {
+	var next atomic.Uint64
+	return BalancerFunc(func(nodes []*Node, key string) *Node {
+		return nodes[(next.Add(1)-1)%uint64(len(nodes))]
+	})
+}
+
+// Random 随机选择
+func Random() Balancer {
+	return BalancerFunc(func(nodes []*Node, key string) *Node {
+		return nodes[rand.IntN(len(nodes))]
+	})
+}
+
+// LeastPending 选择等待响应的调用最少的节点
+func LeastPending() Balancer {
+	return BalancerFunc(func(nodes []*Node, key string) *Node {
+		picked := nodes[0]
+		for _, node := range nodes[1:] {
+			if node.cli.Pending() < picked.cli.Pending() {
+				picked = node
+			}
+		}
+		return picked
+	})
+}
+
+// ConsistentHash 按key做一致性哈希（rendezvous hashing），相同的key总是落在同一个节点上，
+// 节点增减时只有原本落在该节点上的key会被重新分配
+func ConsistentHash() Balancer {
+	return BalancerFunc(func(nodes []*Node, key string) *Node {
+		var picked *Node
+		var max uint64
+		for _, node := range nodes {
+			h := fnv.New64a()
+			h.Write([]byte(node.Endpoint.String()))
+			h.Write([]byte(key))
+			if score := h.Sum64(); picked == nil || score > max {
+				picked, max = node, score
+			}
+		}
+		return picked
+	})
+}
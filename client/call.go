@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+)
+
+var (
+	ErrConnClosed   = errors.New("connection is closed")
+	ErrDisconnected = errors.New("connection lost")
+	ErrCallTimeout  = errors.New("call timeout")
+)
+
+// Reply 服务端对一次调用的响应
+type Reply struct {
+	Serial uint32
+	Tag    uint16
+	Body   []byte
+}
+
+// RemoteError 服务端以ErrorTag回复的错误
+type RemoteError struct {
+	Serial uint32
+	Msg    string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error of serial[%d]: %s", e.Serial, e.Msg)
+}
+
+// Future 一次异步调用的结果
+type Future struct {
+	serial uint32
+	// 期望的响应tag。大多数请求的响应与请求同tag，Ping的响应是Pong
+	replyTag uint16
+	reply    *Reply
+	err      error
+	done     chan struct{}
+	once     sync.Once
+	// 调用完成时需要执行的清理，如停止超时定时器
+	cleanups []func()
+	mtx      sync.Mutex
+}
+
+func newFuture(serial uint32, tag uint16) *Future {
+	f := &Future{
+		serial:   serial,
+		replyTag: tag,
+		done:     make(chan struct{}),
+	}
+	if tag == job.PingTag {
+		f.replyTag = job.PongTag
+	}
+	return f
+}
+
+// complete 设置调用结果，只有第一次调用生效
+func (f *Future) complete(reply *Reply, err error) {
+	f.once.Do(func() {
+		f.mtx.Lock()
+		f.reply, f.err = reply, err
+		close(f.done)
+		cleanups := f.cleanups
+		f.cleanups = nil
+		f.mtx.Unlock()
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	})
+}
+
+// onDone 注册调用完成时执行的清理，调用已经完成时立即执行
+func (f *Future) onDone(cleanup func()) {
+	f.mtx.Lock()
+	select {
+	case <-f.done:
+		f.mtx.Unlock()
+		cleanup()
+		return
+	default:
+	}
+	f.cleanups = append(f.cleanups, cleanup)
+	f.mtx.Unlock()
+}
+
+// matches 判断收到的消息是否是这次调用的响应
+func (f *Future) matches(msg message.ISeqedTLVMsg) bool {
+	return msg.Serial() == f.serial && (msg.Tag() == f.replyTag || msg.Tag() == job.ErrorTag)
+}
+
+// Serial 这次调用使用的序列号
+func (f *Future) Serial() uint32 {
+	return f.serial
+}
+
+// Done 调用完成（成功、失败或超时）时关闭
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result 阻塞直到调用完成，返回响应或错误
+func (f *Future) Result() (*Reply, error) {
+	<-f.done
+	return f.reply, f.err
+}
+
+// Wait 同 Result，但可以通过ctx提前放弃等待（不会取消调用本身）
+func (f *Future) Wait(ctx context.Context) (*Reply, error) {
+	select {
+	case <-f.done:
+		return f.reply, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Call 发送请求并等待响应。
+// 响应通过序列号与请求对应，超时时间取ctx的deadline，ctx没有deadline时使用 WithCallTimeout 设置的默认值
+func (c *Client) Call(ctx context.Context, tag uint16, body []byte) (*Reply, error) {
+	return c.Go(ctx, tag, body).Result()
+}
+
+// Go 异步发送请求，立即返回代表调用结果的 Future
+func (c *Client) Go(ctx context.Context, tag uint16, body []byte) *Future {
+	serial := c.serial.next()
+	f := newFuture(serial, tag)
+
+	c.pendingMtx.Lock()
+	c.pending[serial] = f
+	c.pendingMtx.Unlock()
+
+	if err := c.writeMsg(message.NewSeqedTLVMsg(serial, tag, body)); err != nil {
+		c.finish(serial, nil, err)
+		return f
+	}
+
+	timeout := c.callTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	timeoutErr := fmt.Errorf("%w: serial[%d] tag[%d]", ErrCallTimeout, serial, tag)
+	// 超时定时器放在时间轮上，不需要为每次调用起协程
+	if timeout > 0 {
+		timer := utils.AfterFunc(timeout, func() {
+			c.finish(serial, nil, timeoutErr)
+		})
+		f.onDone(func() { timer.Stop() })
+	}
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				c.finish(serial, nil, timeoutErr)
+			} else {
+				c.finish(serial, nil, ctx.Err())
+			}
+		})
+		f.onDone(func() { stop() })
+	}
+	return f
+}
+
+// Ping 测量与服务端之间的RTT
+func (c *Client) Ping(ctx context.Context) (time.Duration, error) {
+	reply, err := c.Call(ctx, job.PingTag, job.EncodeTimestamp(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	sent, err := job.DecodeTimestamp(reply.Body)
+	if err != nil {
+		return 0, fmt.Errorf("parse pong error: %w", err)
+	}
+	return time.Since(sent), nil
+}
+
+// Pending 当前等待响应的调用个数
+func (c *Client) Pending() int {
+	c.pendingMtx.Lock()
+	defer c.pendingMtx.Unlock()
+	return len(c.pending)
+}
+
+// finish 从pending中移除调用并设置结果
+func (c *Client) finish(serial uint32, reply *Reply, err error) {
+	c.pendingMtx.Lock()
+	f, ok := c.pending[serial]
+	if ok {
+		delete(c.pending, serial)
+	}
+	c.pendingMtx.Unlock()
+	if ok {
+		f.complete(reply, err)
+	}
+}
+
+// resolve 尝试将收到的消息作为某次调用的响应，成功返回true
+func (c *Client) resolve(msg message.ISeqedTLVMsg) bool {
+	c.pendingMtx.Lock()
+	f, ok := c.pending[msg.Serial()]
+	if !ok || !f.matches(msg) {
+		c.pendingMtx.Unlock()
+		return false
+	}
+	delete(c.pending, msg.Serial())
+	c.pendingMtx.Unlock()
+
+	if msg.Tag() == job.ErrorTag && f.replyTag != job.ErrorTag {
+		f.complete(nil, &RemoteError{Serial: msg.Serial(), Msg: string(msg.Body())})
+	} else {
+		f.complete(&Reply{Serial: msg.Serial(), Tag: msg.Tag(), Body: msg.Body()}, nil)
+	}
+	return true
+}
+
+// failPending 连接断开时让所有等待中的调用失败
+func (c *Client) failPending(err error) {
+	c.pendingMtx.Lock()
+	pending := c.pending
+	c.pending = make(map[uint32]*Future)
+	c.pendingMtx.Unlock()
+	for _, f := range pending {
+		f.complete(nil, err)
+	}
+}
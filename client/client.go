@@ -1,20 +1,22 @@
 package client
 
 import (
-	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
-	"os"
-	"os/signal"
+	"strconv"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	"github.com/Meha555/go-tinylog"
+	"github.com/Meha555/pulse/client/task"
 	"github.com/Meha555/pulse/core/message"
-	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/utils"
 
 	"github.com/google/uuid"
 )
@@ -29,8 +31,20 @@ func init() {
 	}
 }
 
+const (
+	// RecvMsg收件箱的容量
+	kDefaultInboxSize = 64
+	// 等待写协程发出的消息队列的容量
+	kDefaultOutQueueSize = 64
+)
+
 type counter struct {
-	count uint32
+	count atomic.Uint32
+}
+
+// next 返回当前序列号并自增
+func (c *counter) next() uint32 {
+	return c.count.Add(1) - 1
 }
 
 type Client struct {
@@ -38,30 +52,118 @@ type Client struct {
 	IPVersion string
 	IP        string
 	Port      uint16
-	conn      *net.TCPConn
+	conn      net.Conn
+	// 当前连接的读协程退出时关闭
+	connDone chan struct{}
+	// 等待写协程发出的消息，所有写操作都由写协程串行执行
+	out chan []byte
+	// 关闭后当前连接的写协程退出
+	writerStop chan struct{}
+	// 当前连接的写协程退出时关闭
+	writerExited chan struct{}
+	// 调用Close后关闭
+	closeCh chan struct{}
+	closed  atomic.Bool
+	// 连接断开且不再重连时关闭
+	lostCh chan struct{}
+	mtx    sync.RWMutex
+
+	// 断线重连的退避策略，为nil表示不重连
+	backoff *Backoff
+	// 断线期间暂存的消息
+	offline        chan []byte
+	state          atomic.Int32
+	stateListeners []func(State)
+	// 服务端在握手消息中下发的会话ID和恢复令牌，服务端未开启会话恢复时为空
+	sessionID   uuid.UUID
+	resumeToken string
+	// 服务端关闭当前连接前通知的原因
+	closeErr atomic.Pointer[CloseError]
+
+	// 不为nil时在TCP之上使用TLS
+	tlsConfig *tls.Config
+	// 连接建立后的认证方式，为nil表示不认证
+	credentials Credentials
+	// 认证通过后服务端回复的用户ID
+	userID string
+
+	// 心跳策略，Interval为0表示不发送心跳
+	heartBeatPolicy common.HeartBeatPolicy
+	// 心跳协程绑定的closeCh，为nil表示心跳协程没有运行
+	heartBeatCh chan struct{}
+	// 心跳计次和统计，同服务端的会话
+	heartbeat atomic.Uint32
+	rtt       atomic.Int64
+	clockSkew atomic.Int64
+	lastSeen  atomic.Int64
 
-	heartBeatInterval time.Duration
-	exitTimeout       time.Duration  // 超时时间，单位：秒
-	wg                sync.WaitGroup // 用于等待所有协程退出，实现优雅退出
+	exitTimeout time.Duration  // Run退出时等待业务函数结束的超时时间
+	callTimeout time.Duration  // Call的默认超时时间
+	wg          sync.WaitGroup // 用于等待所有协程退出，实现优雅退出
 
 	serial counter
+
+	// 等待响应的调用，<serial, future>
+	pending    map[uint32]*Future
+	pendingMtx sync.Mutex
+	// 不属于任何调用的消息，由RecvMsg读取
+	inbox chan *message.SeqedTLVMsg
+
+	// 服务端推送消息的处理函数，<tag, handler>
+	handlers    *utils.Dict[uint16, Handler]
+	handlerPool *task.WorkerPool
+
+	// 订阅的主题，<pattern, handler>
+	subs    map[string]TopicHandler
+	subsMtx sync.RWMutex
 }
 
+// NewClient 创建客户端并立即连接服务端。
+// 连接失败时只记录日志，开启了重连时会在后台重连，需要处理连接错误时使用 Dial
 func NewClient(ip string, port uint16, opts ...ClientOptions) *Client {
+	c := newClient(ip, port, opts...)
+	if err := c.Connect(); err != nil {
+		logger.Errorf("client connect to server %s:%d failed: %v", c.IP, c.Port, err)
+		if c.backoff != nil {
+			go c.reconnect()
+		}
+	}
+	return c
+}
+
+// Dial 创建客户端并连接服务端，连接失败时返回错误
+func Dial(ip string, port uint16, opts ...ClientOptions) (*Client, error) {
+	c := newClient(ip, port, opts...)
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newClient 创建客户端，但不连接
+func newClient(ip string, port uint16, opts ...ClientOptions) *Client {
 	c := &Client{
 		Name:      "github.com/Meha555/pulse Client@" + uuid.New().String(),
 		IPVersion: "tcp4",
 		IP:        ip,
 		Port:      port,
 		conn:      nil,
+		pending:   make(map[uint32]*Future),
+		inbox:     make(chan *message.SeqedTLVMsg, kDefaultInboxSize),
+		out:       make(chan []byte, kDefaultOutQueueSize),
+		handlers:  utils.NewDict(utils.WithCapacity[uint16, Handler](math.MaxUint16 + 1)),
+		lostCh:    make(chan struct{}),
+		subs:      make(map[string]TopicHandler),
+
+		exitTimeout: kDefaultExitTimeout,
 	}
 
 	for _, opt := range opts {
 		opt(c)
 	}
-
-	c.Connect()
-
+	if c.backoff != nil && c.offline == nil {
+		c.offline = make(chan []byte, kDefaultOfflineBufferSize)
+	}
 	return c
 }
 
@@ -79,109 +181,329 @@ func WithIPVersion(network string) ClientOptions {
 	}
 }
 
-func WithExitTimeout(timeout int) ClientOptions {
+// WithExitTimeout 设置 Run 退出时等待业务函数结束的超时时间
+func WithExitTimeout(timeout time.Duration) ClientOptions {
 	return func(cli *Client) {
-		cli.exitTimeout = time.Duration(timeout)
+		cli.exitTimeout = timeout
 	}
 }
 
-func WithHeartBeatInterval(interval int) ClientOptions {
+// WithCallTimeout 设置Call/Go在ctx没有deadline时的默认超时时间，0表示不超时
+func WithCallTimeout(timeout time.Duration) ClientOptions {
 	return func(cli *Client) {
-		cli.heartBeatInterval = time.Duration(interval)
+		cli.callTimeout = timeout
 	}
 }
 
+// Connect 连接服务端，已经调用过 Close 的客户端不能再连接，返回 ErrConnClosed
 func (c *Client) Connect() error {
+	c.mtx.Lock()
 	if c.conn != nil {
+		c.mtx.Unlock()
 		return errors.New("client conn is not nil, maybe already connected")
 	}
-	addr, err := net.ResolveTCPAddr(c.IPVersion, fmt.Sprintf("%s:%d", c.IP, c.Port))
-	if err != nil {
-		return err
+	if c.closed.Load() {
+		c.mtx.Unlock()
+		return ErrConnClosed
+	}
+	if c.closeCh == nil {
+		c.closeCh = make(chan struct{})
+	}
+	select {
+	case <-c.lostCh:
+		c.lostCh = make(chan struct{})
+	default:
+	}
+	c.mtx.Unlock()
+
+	c.setState(StateConnecting)
+	conn, err := c.dial()
+	if err == nil {
+		err = c.attach(conn, true)
 	}
-	conn, err := net.DialTCP(c.IPVersion, nil, addr)
 	if err != nil {
+		c.setState(StateDisconnected)
 		return err
 	}
-	c.conn = conn
-	c.serial.count = 0
 	logger.Infof("client connected to server %s:%d", c.IP, c.Port)
+	c.setState(StateConnected)
+	// 不依赖 Run，连接池和集群中的客户端同样需要心跳
+	c.startHeartBeat()
 	return nil
 }
 
-// Start 启动客户端业务。
-// 客户端业务由fn执行，所有由fn托管的业务逻辑可以保证客户端退出时业务已经结束（在不超时的情况下）
-func (c *Client) Start(parent context.Context, fns ...func()) {
-	logger.Info("client start with jobs...")
-	// c.wg.Add(1)
-	go c.heartBeat() // 不交由c.wg控制，因为心跳协程直接退出不会有影响
-	for _, fn := range fns {
-		c.wg.Add(1)
-		go func() {
-			defer c.wg.Done()
-			fn()
-		}()
+func (c *Client) dial() (net.Conn, error) {
+	addr := net.JoinHostPort(c.IP, strconv.Itoa(int(c.Port)))
+	if c.tlsConfig != nil {
+		return tls.Dial(c.IPVersion, addr, c.tlsConfig)
 	}
+	return net.Dial(c.IPVersion, addr)
+}
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
-	sig := <-sigCh
-	logger.Infof("Received signal: %v (%s), exiting in %v ...", sig, sig, c.exitTimeout*time.Second)
-
-	ctx, cancel := context.WithTimeout(parent, c.exitTimeout*time.Second)
-	defer cancel()
+// attach 使用新建立的连接，并发出断线期间暂存的消息。resetSerial为false时序列号延续之前的值，
+// 否则丢弃上一个连接上还没来得及发出的消息
+func (c *Client) attach(conn net.Conn, resetSerial bool) error {
+	c.mtx.RLock()
+	closeCh, id, token := c.closeCh, c.sessionID, c.resumeToken
+	c.mtx.RUnlock()
 
-	quitCh := make(chan struct{})
+	// 认证和恢复需要与服务端往返，不持有c.mtx，避免阻塞发送和Close。期间调用Close时关闭新连接
+	handshakeDone := make(chan struct{})
 	go func() {
-		logger.Info("Wating for all goroutines to exit")
-		c.wg.Wait()
-		close(quitCh)
+		select {
+		case <-closeCh:
+			conn.Close()
+		case <-handshakeDone:
+		}
 	}()
+	userID, err := c.handshake(conn, resetSerial, id, token)
+	close(handshakeDone)
+	if err != nil {
+		conn.Close()
+		return err
+	}
 
-	select {
-	case <-quitCh:
-		logger.Info("All goroutines exited")
-	case <-ctx.Done():
-		logger.Info("Timeout, force exit")
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed.Load() {
+		conn.Close()
+		return ErrConnClosed
+	}
+	if c.conn != nil {
+		conn.Close()
+		return errors.New("client conn is not nil, maybe already connected")
+	}
+	// 取出上一个连接未发出的消息和断线期间暂存的消息，由写协程先于新消息发出
+	var backlog [][]byte
+	for _, queue := range []chan []byte{c.out, c.offline} {
+		for len(queue) > 0 {
+			data := <-queue
+			if !resetSerial {
+				backlog = append(backlog, data)
+			}
+		}
+	}
+	if c.credentials != nil {
+		c.userID = userID
 	}
-	c.Close()
+	c.conn = conn
+	c.connDone = make(chan struct{})
+	c.writerStop = make(chan struct{})
+	c.writerExited = make(chan struct{})
+	if resetSerial {
+		c.serial.count.Store(0)
+	}
+	c.heartbeat.Store(0)
+	c.closeErr.Store(nil)
+	go c.readLoop(conn, c.connDone)
+	go c.writeLoop(conn, backlog, c.writerStop, c.writerExited)
+	return nil
 }
 
-func (c *Client) Close() {
-
-	if c.conn != nil {
-		c.conn.Close()
+// handshake 在新连接上完成认证，并在需要时发送恢复请求，返回认证通过的用户ID
+func (c *Client) handshake(conn net.Conn, resetSerial bool, id uuid.UUID, token string) (string, error) {
+	var userID string
+	if c.credentials != nil {
+		// 认证必须先于其它所有消息完成
+		var err error
+		if userID, err = c.authenticate(conn); err != nil {
+			return "", err
+		}
+	}
+	if !resetSerial && token != "" {
+		// 恢复请求必须是新连接上的第一条消息
+		if err := c.resume(conn, id, token); err != nil {
+			return "", err
+		}
 	}
+	return userID, nil
+}
+
+// detach 关闭当前连接并等待写协程退出，调用方需持有c.mtx
+func (c *Client) detach() {
+	c.conn.Close()
+	close(c.writerStop)
+	<-c.writerExited
 	c.conn = nil
-	c.serial.count = 0
 }
 
-func (c *Client) Conn() net.TCPConn {
-	return *c.conn
+// onDisconnect 连接意外断开（而不是调用Close）时清理连接，并在开启了重连时开始重连
+func (c *Client) onDisconnect(conn net.Conn) {
+	c.mtx.Lock()
+	if c.conn != conn {
+		// 连接已经被Close或替换
+		c.mtx.Unlock()
+		return
+	}
+	c.detach()
+	c.mtx.Unlock()
+
+	c.setState(StateDisconnected)
+	if c.shouldReconnect() {
+		go c.reconnect()
+	} else {
+		c.markLost()
+	}
 }
 
-func (c *Client) SendMsg(msg message.IPacket) error {
-	if c.conn == nil {
-		return errors.New("connection is closed")
+func (c *Client) Close() {
+	c.mtx.Lock()
+	if c.closed.CompareAndSwap(false, true) && c.closeCh != nil {
+		close(c.closeCh)
 	}
+	if c.conn != nil {
+		c.detach()
+	}
+	c.serial.count.Store(0)
+	c.mtx.Unlock()
+	c.setState(StateDisconnected)
+	c.failPending(ErrConnClosed)
+}
+
+// Conn 返回当前连接，开启TLS时为 *tls.Conn，未连接时为nil
+func (c *Client) Conn() net.Conn {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.conn
+}
+
+// writeMsg 序列化消息并交给写协程发出，不影响序列号。
+// 写协程来不及发出时阻塞，开启了重连时，断线期间的消息会暂存到缓冲区
+func (c *Client) writeMsg(msg message.IPacket) error {
 	data, err := message.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("client send msg marshal error: %w", err)
 	}
-	_, err = c.conn.Write(data)
-	if err != nil {
-		return fmt.Errorf("client send msg write error: %w", err)
+	for {
+		c.mtx.RLock()
+		if c.conn == nil {
+			defer c.mtx.RUnlock()
+			if c.backoff == nil || c.closed.Load() {
+				return ErrConnClosed
+			}
+			select {
+			case c.offline <- data:
+				return nil
+			default:
+				return ErrOfflineBufferFull
+			}
+		}
+		stop := c.writerStop
+		c.mtx.RUnlock()
+		select {
+		case c.out <- data:
+			return nil
+		case <-stop:
+			// 连接在等待期间断开，重新判断是否需要暂存
+		}
+	}
+}
+
+// writeLoop 是连接的写协程，先发出backlog，再按顺序发出out中的消息
+func (c *Client) writeLoop(conn net.Conn, backlog [][]byte, stop <-chan struct{}, exited chan struct{}) {
+	defer close(exited)
+	for _, data := range backlog {
+		if _, err := conn.Write(data); err != nil {
+			logger.Debugf("client flush offline buffer error: %v", err)
+			conn.Close()
+			return
+		}
+	}
+	for {
+		select {
+		case data := <-c.out:
+			if _, err := conn.Write(data); err != nil {
+				logger.Debugf("client write loop exit: %v", err)
+				// 关闭连接让读协程感知断线
+				conn.Close()
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// SendMsg 发送消息并递增序列号，可以被多个协程并发调用
+func (c *Client) SendMsg(msg message.IPacket) error {
+	if err := c.writeMsg(msg); err != nil {
+		return err
 	}
-	c.serial.count++
+	c.serial.count.Add(1)
 	return nil
 }
 
+// RecvMsg 接收一条既不属于任何Call/Go调用、也没有 OnTag 处理函数的消息（系统消息由客户端内部处理）
+// 消息由后台的读协程接收，这里只是从收件箱中取出。连接断开时返回的错误同 Run
 func (c *Client) RecvMsg(msg message.IPacket) error {
-	if c.conn == nil {
-		return errors.New("connection is closed")
+	c.mtx.RLock()
+	done := c.connDone
+	if c.backoff != nil {
+		// 开启了重连时，断线不影响接收，只有Close或放弃重连后才返回
+		done = c.closeCh
+	}
+	lost := c.lostCh
+	c.mtx.RUnlock()
+	if done == nil {
+		return ErrConnClosed
+	}
+	select {
+	case m := <-c.inbox:
+		switch p := msg.(type) {
+		case message.ISeqedTLVMsg:
+			p.SetSerial(m.Serial())
+			p.SetTag(m.Tag())
+		case message.ITLVMsg:
+			p.SetTag(m.Tag())
+		case message.ISeqedMsg:
+			p.SetSerial(m.Serial())
+		}
+		msg.SetBody(m.Body())
+		return nil
+	case <-done:
+		return c.disconnectErr()
+	case <-lost:
+		return c.disconnectErr()
+	}
+}
+
+// readLoop 是连接的读协程，负责将收到的消息分发给等待响应的调用、OnTag 处理函数或收件箱
+func (c *Client) readLoop(conn net.Conn, done chan struct{}) {
+	defer close(done)
+	for {
+		msg := &message.SeqedTLVMsg{}
+		if err := readMsg(conn, msg); err != nil {
+			logger.Debugf("client read loop exit: %v", err)
+			if ce := c.closeErr.Load(); ce != nil {
+				c.failPending(ce)
+			} else {
+				c.failPending(fmt.Errorf("%w: %v", ErrDisconnected, err))
+			}
+			c.onDisconnect(conn)
+			return
+		}
+		if c.heartBeatPolicy.AnyTraffic {
+			c.updateHeartBeat()
+		}
+		c.dispatch(msg)
+	}
+}
+
+// dispatch 分发收到的消息，优先级：系统消息 > 调用的响应 > OnTag 处理函数 > 收件箱
+func (c *Client) dispatch(msg *message.SeqedTLVMsg) {
+	if c.handleSystem(msg) || c.resolve(msg) || c.handle(msg) {
+		return
 	}
+	select {
+	case c.inbox <- msg:
+	default:
+		logger.Warnf("client inbox is full, drop msg serial[%d] tag[%d]", msg.Serial(), msg.Tag())
+	}
+}
+
+func readMsg(conn net.Conn, msg message.IPacket) error {
 	headerData := make([]byte, msg.HeaderLen())
-	if _, err := io.ReadFull(c.conn, headerData); err != nil {
+	if _, err := io.ReadFull(conn, headerData); err != nil {
 		return fmt.Errorf("read header error: %w", err)
 	}
 	if err := message.Unmarshal(headerData, msg, false); err != nil {
@@ -192,7 +514,7 @@ func (c *Client) RecvMsg(msg message.IPacket) error {
 		return nil
 	}
 	bodyData := make([]byte, msg.BodyLen())
-	if _, err := io.ReadFull(c.conn, bodyData); err != nil {
+	if _, err := io.ReadFull(conn, bodyData); err != nil {
 		return fmt.Errorf("read body error: %w", err)
 	}
 	if err := message.UmarshalBodyOnly(bodyData, int(msg.BodyLen()), msg); err != nil {
@@ -200,17 +522,3 @@ func (c *Client) RecvMsg(msg message.IPacket) error {
 	}
 	return nil
 }
-
-// HeartBeat 方法用于向服务器发送心跳消息。
-// 参数 interval 表示心跳消息的发送间隔，单位为秒。
-func (c *Client) heartBeat() {
-	// defer c.wg.Done()
-	ticker := time.NewTicker(c.heartBeatInterval * time.Second)
-	for range ticker.C {
-		msgSent := message.NewSeqedTLVMsg(c.serial.count, job.HeartBeatTag, nil)
-		if err := c.SendMsg(msgSent); err != nil {
-			logger.Errorf("Write error: %v", err)
-			return
-		}
-	}
-}
@@ -0,0 +1,501 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/client/task"
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer 简单的测试服务端，对每个收到的消息调用handle，handle返回的消息会被写回
+type fakeServer struct {
+	listener net.Listener
+	handle   func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg
+	conns    []net.Conn
+	mtx      sync.Mutex
+}
+
+func newFakeServer(t *testing.T, handle func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg) *fakeServer {
+	return listenFakeServer(t, "127.0.0.1:0", handle)
+}
+
+func listenFakeServer(t *testing.T, addr string, handle func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg) *fakeServer {
+	listener, err := net.Listen("tcp4", addr)
+	require.NoError(t, err)
+	s := &fakeServer{listener: listener, handle: handle}
+	go s.serve()
+	t.Cleanup(s.stop)
+	return s
+}
+
+// stop 关闭监听和所有连接，模拟服务端停机
+func (s *fakeServer) stop() {
+	s.listener.Close()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mtx.Lock()
+		s.conns = append(s.conns, conn)
+		s.mtx.Unlock()
+		go func() {
+			defer conn.Close()
+			for {
+				msg := &message.SeqedTLVMsg{}
+				if err := readMsg(conn, msg); err != nil {
+					return
+				}
+				for _, rsp := range s.handle(conn, msg) {
+					data, _ := message.Marshal(rsp)
+					if _, err := conn.Write(data); err != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+}
+
+func (s *fakeServer) port() uint16 {
+	return uint16(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// echo 原样返回，tag为ErrorTag时回复错误，tag为1时不回复
+func echo(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg {
+	switch msg.Tag() {
+	case 1:
+		return nil
+	case 2:
+		return []*message.SeqedTLVMsg{message.NewSeqedTLVMsg(msg.Serial(), job.ErrorTag, []byte("mock error"))}
+	case job.PingTag:
+		return []*message.SeqedTLVMsg{message.NewSeqedTLVMsg(msg.Serial(), job.PongTag, msg.Body())}
+	case 3:
+		conn.Close()
+		return nil
+	default:
+		return []*message.SeqedTLVMsg{
+			// 服务端推送的消息不会被当作响应
+			message.NewSeqedTLVMsg(msg.Serial(), 200, []byte("push")),
+			message.NewSeqedTLVMsg(msg.Serial(), job.HeartBeatTag, nil),
+			message.NewSeqedTLVMsg(msg.Serial(), msg.Tag(), msg.Body()),
+		}
+	}
+}
+
+func TestClient_Call(t *testing.T) {
+	s := newFakeServer(t, echo)
+	cli := NewClient("127.0.0.1", s.port(), WithCallTimeout(time.Second))
+	defer cli.Close()
+
+	t.Run("Reply", func(t *testing.T) {
+		reply, err := cli.Call(context.Background(), 0, []byte("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, uint16(0), reply.Tag)
+		assert.Equal(t, "hello", string(reply.Body))
+
+		// 推送的消息进入收件箱
+		msg := &message.SeqedTLVMsg{}
+		require.NoError(t, cli.RecvMsg(msg))
+		assert.Equal(t, uint16(200), msg.Tag())
+		assert.Equal(t, "push", string(msg.Body()))
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		futures := make([]*Future, 20)
+		for i := range futures {
+			futures[i] = cli.Go(context.Background(), 10, []byte{byte(i)})
+		}
+		for i, f := range futures {
+			reply, err := f.Result()
+			require.NoError(t, err)
+			assert.Equal(t, []byte{byte(i)}, reply.Body)
+		}
+	})
+
+	t.Run("Remote error", func(t *testing.T) {
+		_, err := cli.Call(context.Background(), 2, nil)
+		var remote *RemoteError
+		if assert.ErrorAs(t, err, &remote) {
+			assert.Equal(t, "mock error", remote.Msg)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := cli.Call(ctx, 1, nil)
+		assert.ErrorIs(t, err, ErrCallTimeout)
+		assert.Equal(t, 0, cli.Pending())
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		rtt, err := cli.Ping(context.Background())
+		require.NoError(t, err)
+		assert.Greater(t, rtt, time.Duration(0))
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		pending := cli.Go(context.Background(), 1, nil)
+		_, err := cli.Call(context.Background(), 3, nil)
+		assert.True(t, errors.Is(err, ErrDisconnected), err)
+		_, err = pending.Result()
+		assert.ErrorIs(t, err, ErrDisconnected)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		cli := NewClient("127.0.0.1", s.port(), WithExitTimeout(time.Second))
+		exited := make(chan struct{})
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cli.Close()
+		}()
+		err := cli.Run(context.Background(), func(ctx context.Context) {
+			<-ctx.Done()
+			close(exited)
+		})
+		assert.ErrorIs(t, err, ErrConnClosed)
+		select {
+		case <-exited:
+		default:
+			t.Fatal("Run returned before work function exited")
+		}
+		// 关闭后不能再连接
+		assert.ErrorIs(t, cli.Connect(), ErrConnClosed)
+		assert.Nil(t, cli.Conn())
+	})
+}
+
+func TestClient_OnTag(t *testing.T) {
+	s := newFakeServer(t, echo)
+
+	for name, opts := range map[string][]ClientOptions{
+		"Inline": nil,
+		"Pool":   {WithHandlerPool(newTestPool(t))},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cli := NewClient("127.0.0.1", s.port(), opts...)
+			defer cli.Close()
+
+			pushed := make(chan string, 1)
+			var calls atomic.Int32
+			cli.OnTag(200, HandlerFunc(func(cli *Client, msg message.ISeqedTLVMsg) error {
+				if calls.Add(1) == 1 {
+					panic("first push")
+				}
+				pushed <- string(msg.Body())
+				return nil
+			}))
+
+			// 处理函数panic后，读协程和协程池仍然可以处理后续消息
+			for range 2 {
+				_, err := cli.Call(context.Background(), 0, nil)
+				require.NoError(t, err)
+			}
+			select {
+			case body := <-pushed:
+				assert.Equal(t, "push", body)
+			case <-time.After(time.Second):
+				t.Fatal("handler not called")
+			}
+		})
+	}
+}
+
+func newTestPool(t *testing.T) *task.WorkerPool {
+	pool := task.NewWorkerPool(2, utils.NewBlockingQueue[func()](2))
+	pool.Start()
+	t.Cleanup(pool.Stop)
+	return pool
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	received := make(chan *message.SeqedTLVMsg, 16)
+	record := func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg {
+		if msg.Tag() != job.HeartBeatTag {
+			received <- msg
+		}
+		return echo(conn, msg)
+	}
+	s := newFakeServer(t, record)
+	port := s.port()
+
+	states := make(chan State, 16)
+	cli := NewClient("127.0.0.1", port,
+		WithReconnect(Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2}),
+		WithOfflineBuffer(1),
+		WithStateListener(func(state State) { states <- state }))
+	defer cli.Close()
+	waitState := func(want State) {
+		t.Helper()
+		for {
+			select {
+			case state := <-states:
+				if state == want {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("wait for state %s timeout", want)
+			}
+		}
+	}
+	waitState(StateConnected)
+
+	_, err := cli.Call(context.Background(), 0, nil)
+	require.NoError(t, err)
+	<-received
+
+	// 服务端停机
+	s.stop()
+	waitState(StateDisconnected)
+	waitState(StateConnecting)
+
+	// 断线期间的消息进入缓冲区，缓冲区满后返回错误
+	require.NoError(t, cli.SendMsg(message.NewSeqedTLVMsg(0, 1, []byte("offline"))))
+	assert.ErrorIs(t, cli.SendMsg(message.NewSeqedTLVMsg(0, 1, nil)), ErrOfflineBufferFull)
+
+	// 服务端重启
+	listenFakeServer(t, fmt.Sprintf("127.0.0.1:%d", port), record)
+	waitState(StateConnected)
+	select {
+	case msg := <-received:
+		assert.Equal(t, "offline", string(msg.Body()))
+	case <-time.After(2 * time.Second):
+		t.Fatal("offline msg not flushed")
+	}
+
+	reply, err := cli.Call(context.Background(), 0, nil)
+	require.NoError(t, err)
+	// 序列号延续之前的值
+	assert.Greater(t, reply.Serial, uint32(0))
+}
+
+func TestClient_ConcurrentSend(t *testing.T) {
+	s := newFakeServer(t, echo)
+	cli := NewClient("127.0.0.1", s.port())
+	defer cli.Close()
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := make([]byte, 1024)
+			for j := range body {
+				body[j] = byte(i)
+			}
+			reply, err := cli.Call(context.Background(), 10, body)
+			if assert.NoError(t, err) {
+				assert.Equal(t, body, reply.Body)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClient_Run(t *testing.T) {
+	s := newFakeServer(t, echo)
+
+	t.Run("Cancel", func(t *testing.T) {
+		cli := NewClient("127.0.0.1", s.port(), WithExitTimeout(time.Second))
+		ctx, cancel := context.WithCancel(context.Background())
+		exited := make(chan struct{})
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		err := cli.Run(ctx, func(ctx context.Context) {
+			<-ctx.Done()
+			close(exited)
+		})
+		assert.NoError(t, err)
+		// 退出前等待业务函数结束
+		select {
+		case <-exited:
+		default:
+			t.Fatal("Run returned before work function exited")
+		}
+		assert.Equal(t, StateDisconnected, cli.State())
+	})
+
+	t.Run("Lost", func(t *testing.T) {
+		cli := NewClient("127.0.0.1", s.port(), WithExitTimeout(10*time.Millisecond))
+		err := cli.Run(context.Background(), func(ctx context.Context) {
+			cli.Call(ctx, 3, nil)
+			// 不响应取消的业务函数不会阻塞退出
+			time.Sleep(time.Second)
+		})
+		assert.ErrorIs(t, err, ErrDisconnected)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		cli := NewClient("127.0.0.1", s.port(), WithExitTimeout(time.Second))
+		exited := make(chan struct{})
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cli.Close()
+		}()
+		err := cli.Run(context.Background(), func(ctx context.Context) {
+			<-ctx.Done()
+			close(exited)
+		})
+		assert.ErrorIs(t, err, ErrConnClosed)
+		select {
+		case <-exited:
+		default:
+			t.Fatal("Run returned before work function exited")
+		}
+	})
+}
+
+func TestClient_HeartBeat(t *testing.T) {
+	policy := common.HeartBeatPolicy{
+		Initiator: common.HeartBeatByClient,
+		Interval:  10 * time.Millisecond,
+		MaxMissed: 3,
+	}
+
+	t.Run("Stats", func(t *testing.T) {
+		// 服务端的时钟快一个小时
+		skew := time.Hour
+		s := newFakeServer(t, func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg {
+			if msg.Tag() == job.HeartBeatTag {
+				ack := job.HeartBeatAck(msg.Body(), time.Now().Add(skew))
+				return []*message.SeqedTLVMsg{message.NewSeqedTLVMsg(msg.Serial(), job.HeartBeatTag, ack)}
+			}
+			return echo(conn, msg)
+		})
+		cli := NewClient("127.0.0.1", s.port(), WithHeartBeatPolicy(policy), WithExitTimeout(10*time.Millisecond))
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- cli.Run(ctx) }()
+
+		assert.Eventually(t, func() bool { return cli.HeartBeatStats().RTT > 0 }, time.Second, 10*time.Millisecond)
+		stats := cli.HeartBeatStats()
+		assert.InDelta(t, float64(skew), float64(stats.ClockSkew), float64(100*time.Millisecond))
+		assert.WithinDuration(t, time.Now(), stats.LastSeen, time.Second)
+		cancel()
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("Missed", func(t *testing.T) {
+		// 服务端不回应心跳
+		s := newFakeServer(t, func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg {
+			if msg.Tag() == job.HeartBeatTag {
+				return nil
+			}
+			return echo(conn, msg)
+		})
+		cli := NewClient("127.0.0.1", s.port(), WithHeartBeatPolicy(policy), WithExitTimeout(10*time.Millisecond))
+		done := make(chan error, 1)
+		go func() { done <- cli.Run(context.Background()) }()
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, ErrDisconnected)
+		case <-time.After(time.Second):
+			t.Fatal("connection not closed after missed heartbeats")
+		}
+	})
+}
+
+func TestClient_CloseReason(t *testing.T) {
+	// tag为4时服务端通知关闭原因后断开连接
+	s := newFakeServer(t, func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg {
+		if msg.Tag() != 4 {
+			return echo(conn, msg)
+		}
+		data, _ := message.Marshal(message.NewSeqedTLVMsg(0, job.CloseTag, job.EncodeClose(common.CloseKicked, "bye")))
+		conn.Write(data)
+		conn.Close()
+		return nil
+	})
+
+	states := make(chan State, 16)
+	cli := NewClient("127.0.0.1", s.port(),
+		WithReconnect(Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2}),
+		WithStateListener(func(state State) { states <- state }))
+	defer cli.Close()
+	assert.Nil(t, cli.CloseReason())
+	for len(states) > 0 {
+		<-states
+	}
+
+	_, err := cli.Call(context.Background(), 4, nil)
+	var ce *CloseError
+	require.ErrorAs(t, err, &ce)
+	assert.ErrorIs(t, err, ErrDisconnected)
+	assert.Equal(t, common.CloseKicked, ce.Reason)
+	assert.Equal(t, "bye", ce.Message)
+	assert.Equal(t, ce, cli.CloseReason())
+
+	// 被踢出后不再重连
+	time.Sleep(100 * time.Millisecond)
+	for len(states) > 0 {
+		assert.NotEqual(t, StateConnecting, <-states)
+	}
+	assert.Equal(t, StateDisconnected, cli.State())
+	// 不再重连时RecvMsg返回关闭原因，而不是一直阻塞
+	done := make(chan error, 1)
+	go func() { done <- cli.RecvMsg(&message.SeqedTLVMsg{}) }()
+	select {
+	case err := <-done:
+		assert.ErrorAs(t, err, &ce)
+	case <-time.After(time.Second):
+		t.Fatal("RecvMsg still blocked after the client gave up reconnecting")
+	}
+}
+
+func TestClient_SlowHandshake(t *testing.T) {
+	// 服务端不回应认证消息
+	s := newFakeServer(t, func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg {
+		return nil
+	})
+	cli := newClient("127.0.0.1", s.port(), WithCredentials(TokenCredentials("token")))
+	done := make(chan error, 1)
+	go func() { done <- cli.Connect() }()
+	time.Sleep(20 * time.Millisecond)
+
+	// 认证期间不持有锁，其它操作不会被阻塞
+	queried := make(chan struct{})
+	go func() {
+		cli.UserID()
+		cli.Conn()
+		close(queried)
+	}()
+	select {
+	case <-queried:
+	case <-time.After(time.Second):
+		t.Fatal("client blocked by handshake")
+	}
+
+	// Close立即中断认证
+	cli.Close()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Connect not interrupted by Close")
+	}
+	assert.Nil(t, cli.Conn())
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+)
+
+// CloseError 服务端关闭连接前通过 job.CloseTag 通知的原因，errors.Is(err, ErrDisconnected) 为true
+type CloseError struct {
+	Reason  common.CloseReason
+	Message string
+}
+
+func (e *CloseError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("closed by server: %s", e.Reason)
+	}
+	return fmt.Sprintf("closed by server: %s: %s", e.Reason, e.Message)
+}
+
+func (e *CloseError) Unwrap() error {
+	return ErrDisconnected
+}
+
+// CloseReason 返回服务端关闭上一个连接时通知的原因，服务端没有通知或者已经重新连接时返回nil
+func (c *Client) CloseReason() *CloseError {
+	return c.closeErr.Load()
+}
+
+// onServerClose 记录服务端关闭连接前通知的原因。
+// Body不是关闭码时是对客户端关闭请求的确认，返回false交给调用方处理
+func (c *Client) onServerClose(msg message.ISeqedTLVMsg) bool {
+	reason, text, err := job.DecodeClose(msg.Body())
+	if err != nil {
+		return false
+	}
+	logger.Warnf("server is closing the connection: %s: %s", reason, text)
+	c.closeErr.Store(&CloseError{Reason: reason, Message: text})
+	return true
+}
+
+// disconnectErr 连接断开时返回给调用方的错误，服务端通知了原因时为 *CloseError
+func (c *Client) disconnectErr() error {
+	if ce := c.closeErr.Load(); ce != nil {
+		return ce
+	}
+	return ErrDisconnected
+}
+
+// shouldReconnect 被服务端踢出时不再重连，避免与挤掉它的连接互相踢出
+func (c *Client) shouldReconnect() bool {
+	if c.backoff == nil || c.closed.Load() {
+		return false
+	}
+	ce := c.closeErr.Load()
+	return ce == nil || ce.Reason != common.CloseKicked
+}
@@ -0,0 +1,331 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	kDefaultHealthCheckInterval = 3 * time.Second
+	kDefaultHealthCheckTimeout  = time.Second
+	kDefaultResolveInterval     = 30 * time.Second
+	kDefaultMaxFailover         = 2
+)
+
+var ErrNoAvailableNode = errors.New("no available node")
+
+// Endpoint 服务端地址
+type Endpoint struct {
+	IP   string
+	Port uint16
+}
+
+func (e Endpoint) String() string {
+	return net.JoinHostPort(e.IP, strconv.Itoa(int(e.Port)))
+}
+
+// Resolver 返回当前的服务端地址列表，用于接入服务发现
+type Resolver func(ctx context.Context) ([]Endpoint, error)
+
+// StaticResolver 返回固定地址列表的 Resolver
+func StaticResolver(endpoints ...Endpoint) Resolver {
+	return func(context.Context) ([]Endpoint, error) {
+		return endpoints, nil
+	}
+}
+
+// Node 集群中的一个服务端节点，每个节点对应一个 Client
+type Node struct {
+	Endpoint Endpoint
+	cli      *Client
+	healthy  atomic.Bool
+}
+
+// Client 返回节点的客户端
+func (n *Node) Client() *Client {
+	return n.cli
+}
+
+// Healthy 节点最近一次健康检查或调用是否成功
+func (n *Node) Healthy() bool {
+	return n.healthy.Load()
+}
+
+func (n *Node) setHealthy(healthy bool, err error) {
+	if n.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		logger.Infof("node %s is healthy", n.Endpoint)
+	} else {
+		logger.Warnf("node %s is unhealthy: %v", n.Endpoint, err)
+	}
+}
+
+// Cluster 连接多个服务端的客户端，按 Balancer 选择节点发起调用，节点故障时自动切换到其它节点。
+// 节点的健康状况通过定期Ping（系统tag）检查，断开的节点会在检查时重新连接
+type Cluster struct {
+	resolver   Resolver
+	balancer   Balancer
+	clientOpts []ClientOptions
+
+	healthCheckInterval time.Duration
+	healthCheckTimeout  time.Duration
+	resolveInterval     time.Duration
+	maxFailover         int
+
+	// 按地址排序的节点列表
+	nodes []*Node
+	mtx   sync.RWMutex
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+type ClusterOptions func(*Cluster)
+
+// WithBalancer 设置选择节点的策略，默认为 RoundRobin
+func WithBalancer(balancer Balancer) ClusterOptions {
+	return func(c *Cluster) {
+		c.balancer = balancer
+	}
+}
+
+// WithClientOptions 设置创建各节点的 Client 时使用的选项
+func WithClientOptions(opts ...ClientOptions) ClusterOptions {
+	return func(c *Cluster) {
+		c.clientOpts = append(c.clientOpts, opts...)
+	}
+}
+
+// WithHealthCheck 设置健康检查的间隔和每次Ping的超时时间
+func WithHealthCheck(interval, timeout time.Duration) ClusterOptions {
+	return func(c *Cluster) {
+		c.healthCheckInterval = interval
+		c.healthCheckTimeout = timeout
+	}
+}
+
+// WithResolveInterval 设置重新解析服务端地址列表的间隔，0表示只在创建时解析一次
+func WithResolveInterval(interval time.Duration) ClusterOptions {
+	return func(c *Cluster) {
+		c.resolveInterval = interval
+	}
+}
+
+// WithFailover 设置调用因连接问题失败时最多切换节点重试的次数，0表示不重试
+func WithFailover(retries int) ClusterOptions {
+	return func(c *Cluster) {
+		c.maxFailover = retries
+	}
+}
+
+// NewCluster 创建连接到固定地址列表的集群客户端
+func NewCluster(endpoints []Endpoint, opts ...ClusterOptions) (*Cluster, error) {
+	return NewClusterWithResolver(StaticResolver(endpoints...), append([]ClusterOptions{WithResolveInterval(0)}, opts...)...)
+}
+
+// NewClusterWithResolver 创建通过resolver获取地址列表的集群客户端。
+// 只有第一次解析失败时返回错误，连接不上的节点会被标记为不健康，之后由健康检查重连
+func NewClusterWithResolver(resolver Resolver, opts ...ClusterOptions) (*Cluster, error) {
+	c := &Cluster{
+		resolver:            resolver,
+		balancer:            RoundRobin(),
+		healthCheckInterval: kDefaultHealthCheckInterval,
+		healthCheckTimeout:  kDefaultHealthCheckTimeout,
+		resolveInterval:     kDefaultResolveInterval,
+		maxFailover:         kDefaultMaxFailover,
+		closeCh:             make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	c.checkAll()
+
+	c.wg.Add(1)
+	go c.loop()
+	return c, nil
+}
+
+// Call 选择一个节点发起调用
+func (c *Cluster) Call(ctx context.Context, tag uint16, body []byte) (*Reply, error) {
+	return c.CallKey(ctx, "", tag, body)
+}
+
+// CallKey 同 Call，key用于 ConsistentHash 等按键选择节点的策略。
+// 调用因连接断开而失败时会切换到其它节点重试，此时请求可能已经被原节点处理，所以只应用于可以重复执行的请求
+func (c *Cluster) CallKey(ctx context.Context, key string, tag uint16, body []byte) (*Reply, error) {
+	tried := make(map[*Node]bool)
+	var lastErr error
+	for i := 0; i <= c.maxFailover; i++ {
+		node := c.pick(key, tried)
+		if node == nil {
+			break
+		}
+		tried[node] = true
+		reply, err := node.cli.Call(ctx, tag, body)
+		if err == nil || !isConnError(err) {
+			return reply, err
+		}
+		logger.Warnf("call tag[%d] on node %s failed: %v", tag, node.Endpoint, err)
+		node.setHealthy(false, err)
+		lastErr = err
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoAvailableNode, lastErr)
+	}
+	return nil, ErrNoAvailableNode
+}
+
+// isConnError 判断是否是可以切换节点重试的连接错误
+func isConnError(err error) bool {
+	return errors.Is(err, ErrDisconnected) || errors.Is(err, ErrConnClosed) || errors.Is(err, ErrOfflineBufferFull)
+}
+
+// pick 从未尝试过的健康节点中选择一个，没有可用节点时返回nil
+func (c *Cluster) pick(key string, tried map[*Node]bool) *Node {
+	c.mtx.RLock()
+	candidates := make([]*Node, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		if node.Healthy() && !tried[node] {
+			candidates = append(candidates, node)
+		}
+	}
+	c.mtx.RUnlock()
+	if len(candidates) == 0 {
+		return nil
+	}
+	return c.balancer.Pick(candidates, key)
+}
+
+// Nodes 返回当前所有节点
+func (c *Cluster) Nodes() []*Node {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return slices.Clone(c.nodes)
+}
+
+// Close 关闭所有节点的连接
+func (c *Cluster) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+	})
+	c.wg.Wait()
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, node := range c.nodes {
+		node.cli.Close()
+	}
+	c.nodes = nil
+}
+
+func (c *Cluster) loop() {
+	defer c.wg.Done()
+	healthTicker := time.NewTicker(c.healthCheckInterval)
+	defer healthTicker.Stop()
+	var resolveCh <-chan time.Time
+	if c.resolveInterval > 0 {
+		resolveTicker := time.NewTicker(c.resolveInterval)
+		defer resolveTicker.Stop()
+		resolveCh = resolveTicker.C
+	}
+	for {
+		select {
+		case <-healthTicker.C:
+			c.checkAll()
+		case <-resolveCh:
+			if err := c.refresh(); err != nil {
+				logger.Errorf("resolve endpoints error: %v", err)
+			}
+		case <-c.closeCh:
+			return
+		}
+	}
+}
+
+// refresh 重新解析地址列表，为新地址创建节点，关闭已经不在列表中的节点
+func (c *Cluster) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.healthCheckTimeout)
+	defer cancel()
+	endpoints, err := c.resolver(ctx)
+	if err != nil {
+		return fmt.Errorf("resolve endpoints error: %w", err)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	nodes := make([]*Node, 0, len(endpoints))
+	for _, ep := range endpoints {
+		idx := slices.IndexFunc(c.nodes, func(node *Node) bool { return node.Endpoint == ep })
+		if idx >= 0 {
+			nodes = append(nodes, c.nodes[idx])
+			c.nodes = slices.Delete(c.nodes, idx, idx+1)
+			continue
+		}
+		if slices.ContainsFunc(nodes, func(node *Node) bool { return node.Endpoint == ep }) {
+			continue
+		}
+		nodes = append(nodes, &Node{Endpoint: ep, cli: newClient(ep.IP, ep.Port, c.clientOpts...)})
+	}
+	for _, node := range c.nodes {
+		logger.Infof("node %s is removed", node.Endpoint)
+		node.cli.Close()
+	}
+	slices.SortFunc(nodes, func(a, b *Node) int {
+		return cmp.Or(strings.Compare(a.Endpoint.IP, b.Endpoint.IP), cmp.Compare(a.Endpoint.Port, b.Endpoint.Port))
+	})
+	c.nodes = nodes
+	return nil
+}
+
+// checkAll 并发检查所有节点的健康状况
+func (c *Cluster) checkAll() {
+	var wg sync.WaitGroup
+	for _, node := range c.Nodes() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.check(node)
+		}()
+	}
+	wg.Wait()
+}
+
+// check 通过Ping检查节点是否健康，已断开的节点先尝试重新连接。已经移除或关闭的节点不再检查
+func (c *Cluster) check(node *Node) {
+	select {
+	case <-c.closeCh:
+		return
+	default:
+	}
+	c.mtx.RLock()
+	removed := !slices.Contains(c.nodes, node)
+	c.mtx.RUnlock()
+	if removed || node.cli.closed.Load() {
+		return
+	}
+	if node.cli.State() == StateDisconnected {
+		if err := node.cli.Connect(); err != nil {
+			node.setHealthy(false, err)
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.healthCheckTimeout)
+	defer cancel()
+	_, err := node.cli.Ping(ctx)
+	node.setHealthy(err == nil, err)
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// named 在echo的基础上，对tag为0的请求回复服务端的名字
+func named(name string) func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg {
+	return func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg {
+		if msg.Tag() == 0 {
+			return []*message.SeqedTLVMsg{message.NewSeqedTLVMsg(msg.Serial(), 0, []byte(name))}
+		}
+		return echo(conn, msg)
+	}
+}
+
+func newTestCluster(t *testing.T, servers map[string]*fakeServer, opts ...ClusterOptions) *Cluster {
+	var endpoints []Endpoint
+	for _, s := range servers {
+		endpoints = append(endpoints, Endpoint{IP: "127.0.0.1", Port: s.port()})
+	}
+	opts = append([]ClusterOptions{WithHealthCheck(20*time.Millisecond, time.Second)}, opts...)
+	cluster, err := NewCluster(endpoints, opts...)
+	require.NoError(t, err)
+	t.Cleanup(cluster.Close)
+	return cluster
+}
+
+func callName(t *testing.T, cluster *Cluster, key string) string {
+	t.Helper()
+	reply, err := cluster.CallKey(context.Background(), key, 0, nil)
+	require.NoError(t, err)
+	return string(reply.Body)
+}
+
+func TestCluster_Balancer(t *testing.T) {
+	servers := map[string]*fakeServer{
+		"a": newFakeServer(t, named("a")),
+		"b": newFakeServer(t, named("b")),
+		"c": newFakeServer(t, named("c")),
+	}
+
+	t.Run("RoundRobin", func(t *testing.T) {
+		cluster := newTestCluster(t, servers)
+		counts := make(map[string]int)
+		for range 30 {
+			counts[callName(t, cluster, "")]++
+		}
+		assert.Equal(t, map[string]int{"a": 10, "b": 10, "c": 10}, counts)
+	})
+
+	t.Run("ConsistentHash", func(t *testing.T) {
+		cluster := newTestCluster(t, servers, WithBalancer(ConsistentHash()))
+		for _, key := range []string{"alice", "bob", "carol"} {
+			name := callName(t, cluster, key)
+			for range 5 {
+				assert.Equal(t, name, callName(t, cluster, key))
+			}
+		}
+	})
+
+	t.Run("LeastPending", func(t *testing.T) {
+		cluster := newTestCluster(t, servers, WithBalancer(LeastPending()))
+		// 占住一个节点
+		busy := cluster.Nodes()[0]
+		busy.Client().Go(context.Background(), 1, nil)
+		for range 10 {
+			reply, err := cluster.Call(context.Background(), 0, nil)
+			require.NoError(t, err)
+			assert.NotEqual(t, busy.Endpoint.Port, servers[string(reply.Body)].port())
+		}
+	})
+}
+
+func TestCluster_Failover(t *testing.T) {
+	servers := map[string]*fakeServer{
+		"a": newFakeServer(t, named("a")),
+		"b": newFakeServer(t, named("b")),
+	}
+	cluster := newTestCluster(t, servers, WithBalancer(ConsistentHash()))
+
+	key := "key"
+	name := callName(t, cluster, key)
+	servers[name].stop()
+	// 节点断开后调用切换到另一个节点
+	assert.NotEqual(t, name, callName(t, cluster, key))
+	require.Eventually(t, func() bool {
+		for _, node := range cluster.Nodes() {
+			if node.Endpoint.Port == servers[name].port() {
+				return !node.Healthy()
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+
+	// 所有节点都不可用
+	for _, s := range servers {
+		s.stop()
+	}
+	require.Eventually(t, func() bool {
+		_, err := cluster.Call(context.Background(), 0, nil)
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	_, err := cluster.Call(context.Background(), 0, nil)
+	assert.ErrorIs(t, err, ErrNoAvailableNode)
+}
+
+func TestCluster_Resolve(t *testing.T) {
+	a, b := newFakeServer(t, named("a")), newFakeServer(t, named("b"))
+	var endpoints atomic.Pointer[[]Endpoint]
+	endpoints.Store(&[]Endpoint{{IP: "127.0.0.1", Port: a.port()}, {IP: "127.0.0.1", Port: b.port()}})
+	cluster, err := NewClusterWithResolver(func(context.Context) ([]Endpoint, error) {
+		return *endpoints.Load(), nil
+	}, WithHealthCheck(10*time.Millisecond, time.Second), WithResolveInterval(20*time.Millisecond))
+	require.NoError(t, err)
+	defer cluster.Close()
+	require.Len(t, cluster.Nodes(), 2)
+
+	var removed *Node
+	for _, node := range cluster.Nodes() {
+		if node.Endpoint.Port == b.port() {
+			removed = node
+		}
+	}
+	endpoints.Store(&[]Endpoint{{IP: "127.0.0.1", Port: a.port()}})
+	require.Eventually(t, func() bool { return len(cluster.Nodes()) == 1 }, time.Second, 10*time.Millisecond)
+	// 健康检查不会重新连接已经移除的节点
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, removed.Client().Conn())
+	assert.Equal(t, StateDisconnected, removed.Client().State())
+	assert.Equal(t, "a", callName(t, cluster, "key"))
+}
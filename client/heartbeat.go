@@ -0,0 +1,120 @@
+package client
+
+import (
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+)
+
+// WithHeartBeatInterval 每隔interval秒向服务端发送一次心跳，不检查服务端是否存活
+func WithHeartBeatInterval(interval int) ClientOptions {
+	return WithHeartBeatPolicy(common.HeartBeatPolicy{
+		Initiator: common.HeartBeatByClient,
+		Interval:  time.Duration(interval) * time.Second,
+	})
+}
+
+// WithHeartBeatPolicy 设置心跳策略，应与服务端的策略一致。
+// 设置了MaxMissed时，连续多个间隔没有收到服务端的心跳或回应会断开连接，开启了重连时随后重连
+func WithHeartBeatPolicy(policy common.HeartBeatPolicy) ClientOptions {
+	return func(cli *Client) {
+		cli.heartBeatPolicy = policy
+	}
+}
+
+// HeartBeatStats 返回心跳统计，RTT和时钟偏差在收到服务端对客户端心跳的回应后才有值
+func (c *Client) HeartBeatStats() common.HeartBeatStats {
+	stats := common.HeartBeatStats{
+		RTT:       time.Duration(c.rtt.Load()),
+		ClockSkew: time.Duration(c.clockSkew.Load()),
+		Missed:    uint(c.heartbeat.Load()),
+	}
+	if seen := c.lastSeen.Load(); seen != 0 {
+		stats.LastSeen = time.Unix(0, seen)
+	}
+	return stats
+}
+
+// updateHeartBeat 服务端存活，清零心跳计次
+func (c *Client) updateHeartBeat() {
+	c.heartbeat.Store(0)
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+// onHeartBeat 处理服务端的心跳：回应带时间戳的心跳，或者根据对客户端心跳的回应更新统计
+func (c *Client) onHeartBeat(msg message.ISeqedTLVMsg) {
+	now := time.Now()
+	c.updateHeartBeat()
+	if ack := job.HeartBeatAck(msg.Body(), now); ack != nil {
+		if err := c.writeMsg(message.NewSeqedTLVMsg(msg.Serial(), job.HeartBeatTag, ack)); err != nil {
+			logger.Errorf("reply heartbeat error: %v", err)
+		}
+		return
+	}
+	if rtt, skew, ok := job.MeasureHeartBeat(msg.Body(), now); ok {
+		c.rtt.Store(int64(rtt))
+		c.clockSkew.Store(int64(skew))
+	}
+}
+
+// startHeartBeat 设置了心跳策略时开启心跳协程，直到客户端被关闭。已经开启时不重复开启
+func (c *Client) startHeartBeat() {
+	if c.heartBeatPolicy.Interval <= 0 {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closeCh == nil || c.heartBeatCh == c.closeCh {
+		return
+	}
+	c.heartBeatCh = c.closeCh
+	go c.heartBeat(c.closeCh)
+}
+
+// heartBeat 按心跳策略检查服务端是否存活，需要时发送心跳，直到done被关闭
+func (c *Client) heartBeat(done chan struct{}) {
+	defer func() {
+		c.mtx.Lock()
+		if c.heartBeatCh == done {
+			c.heartBeatCh = nil
+		}
+		c.mtx.Unlock()
+	}()
+	policy := c.heartBeatPolicy
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+		if c.backoff != nil && c.State() != StateConnected {
+			// 断线重连期间不发送心跳
+			continue
+		}
+		if policy.MaxMissed > 0 && uint(c.heartbeat.Load()) >= policy.MaxMissed {
+			logger.Warnf("missed %d heartbeats from server, close the connection", c.heartbeat.Load())
+			if conn := c.Conn(); conn != nil {
+				// 读协程感知断线后按是否开启重连处理
+				conn.Close()
+			}
+			c.heartbeat.Store(0)
+			continue
+		}
+		c.heartbeat.Add(1)
+		if !policy.ClientSends() {
+			continue
+		}
+		msgSent := message.NewSeqedTLVMsg(c.serial.count.Load(), job.HeartBeatTag, job.EncodeTimestamp(time.Now()))
+		if err := c.SendMsg(msgSent); err != nil {
+			logger.Errorf("Write error: %v", err)
+			if c.backoff != nil && !c.closed.Load() {
+				continue
+			}
+			return
+		}
+	}
+}
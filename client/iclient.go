@@ -9,9 +9,16 @@ import (
 
 type IClient interface {
 	Connect() error
-	Start(parent context.Context, fns ...func())
+	// 运行业务函数，直到ctx被取消或连接无法恢复
+	Run(ctx context.Context, fns ...func(ctx context.Context)) error
 	Close()
-	Conn() net.TCPConn
+	Conn() net.Conn
 	SendMsg(msg message.IPacket) error
 	RecvMsg(msg message.IPacket) error
+	// 发送请求并等待对应的响应
+	Call(ctx context.Context, tag uint16, body []byte) (*Reply, error)
+	// 异步发送请求
+	Go(ctx context.Context, tag uint16, body []byte) *Future
 }
+
+var _ IClient = (*Client)(nil)
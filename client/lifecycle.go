@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// Run退出时等待业务函数结束的默认超时时间
+	kDefaultExitTimeout = 5 * time.Second
+)
+
+// markLost 标记连接已断开且不会再重连，通知 Run 退出
+func (c *Client) markLost() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	select {
+	case <-c.lostCh:
+	default:
+		close(c.lostCh)
+	}
+}
+
+// Run 启动心跳和业务函数，直到ctx被取消、客户端被 Close 或连接断开且无法恢复（未开启重连或重连放弃）。
+// 退出时先取消传给业务函数的ctx，最多等待 WithExitTimeout 设置的时间让它们结束，然后关闭客户端。
+// ctx被取消时返回nil，客户端被关闭时返回 ErrConnClosed，连接无法恢复时返回 ErrDisconnected，服务端通知了关闭原因时为 *CloseError
+func (c *Client) Run(ctx context.Context, fns ...func(ctx context.Context)) error {
+	c.mtx.RLock()
+	lost := c.lostCh
+	closed := c.closeCh
+	c.mtx.RUnlock()
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 通常已经在连接时开启，不交由c.wg控制，因为心跳协程直接退出不会有影响
+	c.startHeartBeat()
+	for _, fn := range fns {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			fn(runCtx)
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		logger.Infof("client context done, exiting in %v ...", c.exitTimeout)
+	case <-lost:
+		err = c.disconnectErr()
+		logger.Errorf("client connection lost, exiting in %v ...", c.exitTimeout)
+	case <-closed:
+		err = ErrConnClosed
+		logger.Infof("client closed, exiting in %v ...", c.exitTimeout)
+	}
+	cancel()
+
+	quitCh := make(chan struct{})
+	go func() {
+		logger.Info("Wating for all goroutines to exit")
+		c.wg.Wait()
+		close(quitCh)
+	}()
+
+	select {
+	case <-quitCh:
+		logger.Info("All goroutines exited")
+	case <-time.After(c.exitTimeout):
+		logger.Info("Timeout, force exit")
+	}
+	c.Close()
+	return err
+}
+
+// Start 同 Run，但会在收到SIGINT/SIGTERM/SIGQUIT/SIGHUP信号时退出。
+// 适用于独占进程的客户端程序，嵌入其它服务时应使用 Run 并自行处理信号
+func (c *Client) Start(parent context.Context, fns ...func(ctx context.Context)) error {
+	ctx, stop := signal.NotifyContext(parent, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+	defer stop()
+	return c.Run(ctx, fns...)
+}
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	kDefaultPoolMinSize     = 1
+	kDefaultPoolMaxSize     = 8
+	kDefaultPoolIdleTimeout = time.Minute
+	kDefaultPoolMaxPending  = 128
+)
+
+var ErrPoolExhausted = errors.New("client pool exhausted")
+
+// pooledClient 连接池中的一个连接
+type pooledClient struct {
+	cli *Client
+	// 最近一次被使用的时间（unix纳秒）
+	lastUsed atomic.Int64
+	// 从连接池预留、尚未完成的调用数，在acquire中持有锁时递增，调用完成时递减
+	inflight atomic.Int32
+}
+
+// ClientPool 将调用分摊到同一服务端的多个连接上。
+// 优先使用等待响应最少的连接，所有连接的等待数都达到上限时新建连接，连接数达到上限时调用返回 ErrPoolExhausted
+type ClientPool struct {
+	IP   string
+	Port uint16
+
+	clientOpts  []ClientOptions
+	minSize     int
+	maxSize     int
+	idleTimeout time.Duration
+	maxPending  int
+
+	clients []*pooledClient
+	// 正在建立的连接数，计入连接数上限
+	dialing int
+	mtx     sync.Mutex
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+type PoolOptions func(*ClientPool)
+
+// WithPoolSize 设置连接数的下限和上限
+func WithPoolSize(min, max int) PoolOptions {
+	return func(p *ClientPool) {
+		p.minSize, p.maxSize = min, max
+	}
+}
+
+// WithIdleTimeout 设置连接的空闲时间，超过空闲时间的连接会被关闭，但连接数不会低于下限
+func WithIdleTimeout(timeout time.Duration) PoolOptions {
+	return func(p *ClientPool) {
+		p.idleTimeout = timeout
+	}
+}
+
+// WithMaxPending 设置每个连接上等待响应的调用数上限
+func WithMaxPending(max int) PoolOptions {
+	return func(p *ClientPool) {
+		p.maxPending = max
+	}
+}
+
+// WithPoolClientOptions 设置创建连接时使用的 Client 选项
+func WithPoolClientOptions(opts ...ClientOptions) PoolOptions {
+	return func(p *ClientPool) {
+		p.clientOpts = append(p.clientOpts, opts...)
+	}
+}
+
+// NewClientPool 创建连接池并建立下限个数的连接，任一连接失败时返回错误
+func NewClientPool(ip string, port uint16, opts ...PoolOptions) (*ClientPool, error) {
+	p := &ClientPool{
+		IP:          ip,
+		Port:        port,
+		minSize:     kDefaultPoolMinSize,
+		maxSize:     kDefaultPoolMaxSize,
+		idleTimeout: kDefaultPoolIdleTimeout,
+		maxPending:  kDefaultPoolMaxPending,
+		closeCh:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.minSize < 0 || p.maxSize < 1 || p.minSize > p.maxSize {
+		return nil, fmt.Errorf("invalid pool size [%d, %d]", p.minSize, p.maxSize)
+	}
+
+	for range p.minSize {
+		cli, err := Dial(ip, port, p.clientOpts...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.clients = append(p.clients, newPooledClient(cli))
+	}
+
+	if p.idleTimeout > 0 {
+		p.wg.Add(1)
+		go p.evictLoop()
+	}
+	return p, nil
+}
+
+func newPooledClient(cli *Client) *pooledClient {
+	pc := &pooledClient{cli: cli}
+	pc.lastUsed.Store(time.Now().UnixNano())
+	return pc
+}
+
+// load 返回连接上等待响应的调用数，包括已经预留但尚未发出的调用
+func (pc *pooledClient) load() int {
+	return max(pc.cli.Pending(), int(pc.inflight.Load()))
+}
+
+// release 归还acquire预留的名额
+func (pc *pooledClient) release() {
+	pc.inflight.Add(-1)
+}
+
+// Call 选择一个连接发起调用
+func (p *ClientPool) Call(ctx context.Context, tag uint16, body []byte) (*Reply, error) {
+	pc, err := p.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer pc.release()
+	return pc.cli.Call(ctx, tag, body)
+}
+
+// Go 选择一个连接发起异步调用，没有可用连接时返回错误
+func (p *ClientPool) Go(ctx context.Context, tag uint16, body []byte) (*Future, error) {
+	pc, err := p.acquire()
+	if err != nil {
+		return nil, err
+	}
+	f := pc.cli.Go(ctx, tag, body)
+	f.onDone(pc.release)
+	return f, nil
+}
+
+// Len 当前的连接数
+func (p *ClientPool) Len() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return len(p.clients)
+}
+
+// Close 关闭所有连接
+func (p *ClientPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closeCh)
+	})
+	p.wg.Wait()
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, pc := range p.clients {
+		pc.cli.Close()
+	}
+	p.clients = nil
+}
+
+// acquire 选择等待响应最少且未达到上限的连接并预留一个名额，没有时新建连接。调用完成后需调用release
+func (p *ClientPool) acquire() (*pooledClient, error) {
+	p.mtx.Lock()
+	select {
+	case <-p.closeCh:
+		p.mtx.Unlock()
+		return nil, ErrConnClosed
+	default:
+	}
+	var picked *pooledClient
+	alive := p.clients[:0]
+	for _, pc := range p.clients {
+		if pc.cli.State() == StateDisconnected {
+			// 断开的连接直接移除，需要时再新建
+			pc.cli.Close()
+			continue
+		}
+		alive = append(alive, pc)
+		if load := pc.load(); load < p.maxPending && (picked == nil || load < picked.load()) {
+			picked = pc
+		}
+	}
+	p.clients = alive
+	if picked != nil {
+		// 持有锁时预留，并发的调用不会都选中同一个连接
+		picked.inflight.Add(1)
+		picked.lastUsed.Store(time.Now().UnixNano())
+		p.mtx.Unlock()
+		return picked, nil
+	}
+	if len(p.clients)+p.dialing >= p.maxSize {
+		p.mtx.Unlock()
+		return nil, ErrPoolExhausted
+	}
+	p.dialing++
+	p.mtx.Unlock()
+
+	// 建立连接时不持有锁，避免阻塞其它调用
+	cli, err := Dial(p.IP, p.Port, p.clientOpts...)
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.dialing--
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-p.closeCh:
+		// 建立连接期间连接池被关闭，Close不会再关闭这个连接
+		cli.Close()
+		return nil, ErrConnClosed
+	default:
+	}
+	pc := newPooledClient(cli)
+	pc.inflight.Add(1)
+	p.clients = append(p.clients, pc)
+	logger.Debugf("client pool grows to %d connections", len(p.clients))
+	return pc, nil
+}
+
+func (p *ClientPool) evictLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.evict()
+		case <-p.closeCh:
+			return
+		}
+	}
+}
+
+// evict 关闭空闲超时的连接，保留下限个数的连接
+func (p *ClientPool) evict() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	deadline := time.Now().Add(-p.idleTimeout).UnixNano()
+	kept := p.clients[:0]
+	for i, pc := range p.clients {
+		if len(kept)+len(p.clients)-i > p.minSize && pc.load() == 0 && pc.lastUsed.Load() < deadline {
+			pc.cli.Close()
+			continue
+		}
+		kept = append(kept, pc)
+	}
+	p.clients = kept
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientPool(t *testing.T) {
+	s := newFakeServer(t, echo)
+
+	t.Run("Grow", func(t *testing.T) {
+		pool, err := NewClientPool("127.0.0.1", s.port(), WithPoolSize(1, 2), WithMaxPending(1))
+		require.NoError(t, err)
+		defer pool.Close()
+		assert.Equal(t, 1, pool.Len())
+
+		// tag为1的请求不会被回复，一直占用连接
+		for range 2 {
+			_, err := pool.Go(context.Background(), 1, nil)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 2, pool.Len())
+		_, err = pool.Go(context.Background(), 1, nil)
+		assert.ErrorIs(t, err, ErrPoolExhausted)
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		pool, err := NewClientPool("127.0.0.1", s.port(), WithPoolSize(1, 4), WithMaxPending(1), WithIdleTimeout(50*time.Millisecond))
+		require.NoError(t, err)
+		defer pool.Close()
+
+		// 未回复的调用超时前连接不会被回收
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+		for range 4 {
+			_, err = pool.Go(ctx, 1, nil)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 4, pool.Len())
+		assert.Eventually(t, func() bool { return pool.Len() == 1 }, time.Second, 10*time.Millisecond)
+
+		_, err = pool.Call(context.Background(), 0, nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		pool, err := NewClientPool("127.0.0.1", s.port(), WithPoolSize(1, 4), WithMaxPending(1))
+		require.NoError(t, err)
+		defer pool.Close()
+
+		// 并发的调用各自预留名额，每个连接上最多一个
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := pool.Go(context.Background(), 1, nil)
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 4, pool.Len())
+		_, err = pool.Go(context.Background(), 1, nil)
+		assert.ErrorIs(t, err, ErrPoolExhausted)
+	})
+
+	t.Run("HeartBeat", func(t *testing.T) {
+		beats := make(chan struct{}, 16)
+		hb := newFakeServer(t, func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg {
+			if msg.Tag() == job.HeartBeatTag {
+				beats <- struct{}{}
+				return nil
+			}
+			return echo(conn, msg)
+		})
+		pool, err := NewClientPool("127.0.0.1", hb.port(), WithPoolClientOptions(WithHeartBeatPolicy(common.HeartBeatPolicy{
+			Initiator: common.HeartBeatByClient,
+			Interval:  20 * time.Millisecond,
+		})))
+		require.NoError(t, err)
+		defer pool.Close()
+
+		// 连接池中的客户端没有调用Run，同样发送心跳
+		select {
+		case <-beats:
+		case <-time.After(time.Second):
+			t.Fatal("pooled client sent no heartbeat")
+		}
+	})
+
+	t.Run("CloseWhileDialing", func(t *testing.T) {
+		// 认证延迟回复，新建连接时有足够的时间关闭连接池
+		slow := newFakeServer(t, func(conn net.Conn, msg *message.SeqedTLVMsg) []*message.SeqedTLVMsg {
+			if msg.Tag() == job.AuthTag {
+				time.Sleep(50 * time.Millisecond)
+				return []*message.SeqedTLVMsg{message.NewSeqedTLVMsg(msg.Serial(), job.AuthTag, []byte("alice"))}
+			}
+			return echo(conn, msg)
+		})
+		pool, err := NewClientPool("127.0.0.1", slow.port(), WithPoolSize(0, 1),
+			WithPoolClientOptions(WithCredentials(TokenCredentials("token"))))
+		require.NoError(t, err)
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := pool.Go(context.Background(), 0, nil)
+			errCh <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		pool.Close()
+		// 关闭后建立的连接不会加入连接池
+		assert.ErrorIs(t, <-errCh, ErrConnClosed)
+		assert.Equal(t, 0, pool.Len())
+	})
+}
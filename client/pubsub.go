@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/core/topic"
+	"github.com/Meha555/pulse/server/job"
+)
+
+const (
+	// 重连后重新订阅每个主题的超时时间
+	kDefaultResubscribeTimeout = 5 * time.Second
+)
+
+// TopicHandler 处理订阅的主题收到的消息，执行方式与 OnTag 注册的处理函数相同
+type TopicHandler func(topic string, payload []byte)
+
+// Subscribe 订阅主题，pattern可以包含通配符（见 topic 包）。
+// 重复订阅同一个pattern会替换处理函数；开启了重连时，重连成功后会自动重新订阅。ctx的用法同 Call
+func (c *Client) Subscribe(ctx context.Context, pattern string, handler TopicHandler) error {
+	if err := topic.ValidatePattern(pattern); err != nil {
+		return err
+	}
+	// 先注册处理函数，避免错过订阅成功后立即投递的消息
+	c.subsMtx.Lock()
+	_, existed := c.subs[pattern]
+	c.subs[pattern] = handler
+	c.subsMtx.Unlock()
+
+	if _, err := c.Call(ctx, job.SubscribeTag, []byte(pattern)); err != nil {
+		if !existed {
+			c.subsMtx.Lock()
+			delete(c.subs, pattern)
+			c.subsMtx.Unlock()
+		}
+		return fmt.Errorf("subscribe %s error: %w", pattern, err)
+	}
+	return nil
+}
+
+// Unsubscribe 取消订阅，pattern需要与订阅时一致
+func (c *Client) Unsubscribe(ctx context.Context, pattern string) error {
+	c.subsMtx.Lock()
+	delete(c.subs, pattern)
+	c.subsMtx.Unlock()
+	if _, err := c.Call(ctx, job.UnsubscribeTag, []byte(pattern)); err != nil {
+		return fmt.Errorf("unsubscribe %s error: %w", pattern, err)
+	}
+	return nil
+}
+
+// Publish 向主题发布消息，返回时服务端已经将消息交给订阅者的投递队列
+func (c *Client) Publish(ctx context.Context, name string, payload []byte) error {
+	if err := topic.ValidateTopic(name); err != nil {
+		return err
+	}
+	if _, err := c.Call(ctx, job.PublishTag, topic.Encode(name, payload)); err != nil {
+		return fmt.Errorf("publish %s error: %w", name, err)
+	}
+	return nil
+}
+
+// deliver 将服务端投递的消息交给所有匹配的处理函数
+func (c *Client) deliver(msg message.ISeqedTLVMsg) {
+	name, payload, err := topic.Decode(msg.Body())
+	if err != nil {
+		logger.Errorf("parse delivered msg error: %v", err)
+		return
+	}
+	c.subsMtx.RLock()
+	var handlers []TopicHandler
+	for pattern, handler := range c.subs {
+		if topic.Match(pattern, name) {
+			handlers = append(handlers, handler)
+		}
+	}
+	c.subsMtx.RUnlock()
+	for _, handler := range handlers {
+		c.exec(msg.Tag(), func() error {
+			handler(name, payload)
+			return nil
+		})
+	}
+}
+
+// resubscribe 重连后重新订阅所有主题，每个主题最多等待 kDefaultResubscribeTimeout。
+// 会话被恢复时服务端的订阅仍然有效，重复订阅没有副作用
+func (c *Client) resubscribe() {
+	c.subsMtx.RLock()
+	patterns := make([]string, 0, len(c.subs))
+	for pattern := range c.subs {
+		patterns = append(patterns, pattern)
+	}
+	c.subsMtx.RUnlock()
+	for _, pattern := range patterns {
+		ctx, cancel := context.WithTimeout(context.Background(), kDefaultResubscribeTimeout)
+		_, err := c.Call(ctx, job.SubscribeTag, []byte(pattern))
+		cancel()
+		if err != nil {
+			logger.Errorf("resubscribe %s error: %v", pattern, err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/job"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// 断线期间发出的消息的缓冲区大小
+	kDefaultOfflineBufferSize = 64
+)
+
+var ErrOfflineBufferFull = errors.New("offline buffer is full")
+
+// State 客户端的连接状态
+type State int32
+
+const (
+	StateDisconnected State = iota
+	StateConnecting
+	StateConnected
+)
+
+func (s State) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	default:
+		return "unknown"
+	}
+}
+
+// Backoff 断线重连的指数退避策略
+type Backoff struct {
+	// 第一次重试前的等待时间
+	Initial time.Duration
+	// 等待时间的上限
+	Max time.Duration
+	// 每次重试后等待时间的增长倍数
+	Multiplier float64
+	// 随机抖动的比例（0~1），实际等待时间在 [d*(1-Jitter), d*(1+Jitter)] 之间，避免大量客户端同时重连
+	Jitter float64
+	// 最大重试次数，0表示无限重试
+	MaxRetries int
+}
+
+// DefaultBackoff 默认的退避策略
+var DefaultBackoff = Backoff{
+	Initial:    100 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay 返回第attempt次（从0开始）重试失败后的等待时间
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d *= 1 + b.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// WithReconnect 开启断线自动重连。
+// 断线期间发出的消息会被暂存到缓冲区，重连成功后按顺序发出，序列号也会延续而不是从0开始
+func WithReconnect(backoff Backoff) ClientOptions {
+	return func(cli *Client) {
+		cli.backoff = &backoff
+	}
+}
+
+// WithOfflineBuffer 设置断线期间暂存消息的缓冲区大小，缓冲区满时发送会返回 ErrOfflineBufferFull
+func WithOfflineBuffer(size int) ClientOptions {
+	return func(cli *Client) {
+		cli.offline = make(chan []byte, size)
+	}
+}
+
+// WithStateListener 监听连接状态的变化。回调在改变状态的协程中同步执行，不应阻塞
+func WithStateListener(listener func(State)) ClientOptions {
+	return func(cli *Client) {
+		cli.stateListeners = append(cli.stateListeners, listener)
+	}
+}
+
+// State 返回当前的连接状态
+func (c *Client) State() State {
+	return State(c.state.Load())
+}
+
+func (c *Client) setState(state State) {
+	if State(c.state.Swap(int32(state))) == state {
+		return
+	}
+	logger.Debugf("client state changed to %s", state)
+	for _, listener := range c.stateListeners {
+		listener(state)
+	}
+}
+
+// reconnect 按退避策略不断重连，直到成功、客户端被关闭或重试次数用完
+func (c *Client) reconnect() {
+	c.mtx.RLock()
+	closeCh := c.closeCh
+	c.mtx.RUnlock()
+
+	c.setState(StateConnecting)
+	for attempt := 0; c.backoff.MaxRetries == 0 || attempt < c.backoff.MaxRetries; attempt++ {
+		conn, err := c.dial()
+		if err == nil {
+			if err = c.attach(conn, false); err == nil {
+				logger.Infof("client reconnected to server %s:%d", c.IP, c.Port)
+				c.setState(StateConnected)
+				go c.resubscribe()
+				return
+			}
+		}
+		delay := c.backoff.Delay(attempt)
+		logger.Warnf("client reconnect failed (attempt %d): %v, retry in %v", attempt+1, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-closeCh:
+			return
+		}
+	}
+	logger.Errorf("client give up reconnecting to server %s:%d", c.IP, c.Port)
+	c.setState(StateDisconnected)
+	c.markLost()
+}
+
+// SessionID 返回服务端在握手消息中下发的会话ID，服务端未开启会话恢复时为零值
+func (c *Client) SessionID() uuid.UUID {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.sessionID
+}
+
+// onHandshake 记录服务端下发的会话ID和恢复令牌，用于重连后恢复会话
+func (c *Client) onHandshake(msg message.ISeqedTLVMsg) {
+	id, token, err := job.DecodeHandshake(msg.Body())
+	if err != nil {
+		logger.Errorf("parse handshake error: %v", err)
+		return
+	}
+	c.mtx.Lock()
+	c.sessionID, c.resumeToken = id, token
+	c.mtx.Unlock()
+}
+
+// resume 在新连接上发送恢复会话id的请求。
+// 恢复失败时服务端以新会话继续服务，新会话的握手消息先于恢复请求的响应到达
+func (c *Client) resume(conn net.Conn, id uuid.UUID, token string) error {
+	serial := c.serial.next()
+	f := newFuture(serial, job.ResumeTag)
+	c.pendingMtx.Lock()
+	c.pending[serial] = f
+	c.pendingMtx.Unlock()
+
+	data, err := message.Marshal(message.NewSeqedTLVMsg(serial, job.ResumeTag, []byte(token)))
+	if err == nil {
+		_, err = conn.Write(data)
+	}
+	if err != nil {
+		c.finish(serial, nil, err)
+		return fmt.Errorf("send resume request error: %w", err)
+	}
+
+	go func() {
+		if _, err := f.Result(); err != nil {
+			logger.Warnf("client resume session %s failed: %v", id, err)
+			return
+		}
+		logger.Infof("client resumed session %s", id)
+	}()
+	return nil
+}
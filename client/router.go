@@ -0,0 +1,109 @@
+package client
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/Meha555/pulse/client/task"
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/job"
+)
+
+// Handler 处理服务端推送的消息，与服务端的 job.IJob 相对应
+type Handler interface {
+	Handle(cli *Client, msg message.ISeqedTLVMsg) error
+}
+
+type HandlerFunc func(cli *Client, msg message.ISeqedTLVMsg) error
+
+func (f HandlerFunc) Handle(cli *Client, msg message.ISeqedTLVMsg) error {
+	return f(cli, msg)
+}
+
+// WithHandlerPool 让 OnTag 注册的处理函数在协程池中执行。
+// 不设置时处理函数直接在读协程中执行，能保证处理顺序，但耗时的处理会阻塞后续消息的接收
+func WithHandlerPool(pool *task.WorkerPool) ClientOptions {
+	return func(cli *Client) {
+		cli.handlerPool = pool
+	}
+}
+
+// OnTag 注册指定tag的消息处理函数，与 server.Server.Route 相对应。
+// 系统tag（如心跳）由客户端内部处理，不能注册
+func (c *Client) OnTag(tag uint16, handler Handler) *Client {
+	if job.IsSystemTag(tag) {
+		logger.Errorf("tag[%d] is reserved for system", tag)
+		return c
+	}
+	if err := c.handlers.Store(tag, handler); err != nil {
+		logger.Errorf("register handler for tag[%d] failed: %v", tag, err)
+	}
+	return c
+}
+
+// handleSystem 处理系统tag的消息，返回是否已处理
+func (c *Client) handleSystem(msg message.ISeqedTLVMsg) bool {
+	switch msg.Tag() {
+	case job.HeartBeatTag:
+		c.onHeartBeat(msg)
+		return true
+	case job.PingTag:
+		// 服务端发起的Ping，原样回复Pong以便服务端测量RTT
+		if err := c.writeMsg(message.NewSeqedTLVMsg(msg.Serial(), job.PongTag, msg.Body())); err != nil {
+			logger.Errorf("reply pong error: %v", err)
+		}
+		return true
+	case job.CloseTag:
+		return c.onServerClose(msg)
+	case job.HandshakeTag:
+		c.onHandshake(msg)
+		return true
+	case job.DeliverTag:
+		c.deliver(msg)
+		return true
+	case job.ResumeTag:
+		// 恢复成功的确认，Body同握手消息
+		c.onHandshake(msg)
+		c.resolve(msg)
+		return true
+	}
+	return false
+}
+
+// handle 将消息交给 OnTag 注册的处理函数，没有对应的处理函数时返回false
+func (c *Client) handle(msg message.ISeqedTLVMsg) bool {
+	handler, ok := c.handlers.Load(msg.Tag())
+	if !ok {
+		return false
+	}
+	c.exec(msg.Tag(), func() error {
+		return handler.Handle(c, msg)
+	})
+	return true
+}
+
+// exec 执行处理函数：设置了协程池时在协程池中执行，否则在读协程中执行
+func (c *Client) exec(tag uint16, fn func() error) {
+	if c.handlerPool != nil {
+		c.handlerPool.Post(func() {
+			if err := safeExec(tag, fn); err != nil {
+				logger.Errorf("handle msg of tag[%d] error: %v", tag, err)
+			}
+		})
+		return
+	}
+	if err := safeExec(tag, fn); err != nil {
+		logger.Errorf("handle msg of tag[%d] error: %v", tag, err)
+	}
+}
+
+// safeExec 执行处理函数，避免处理函数的panic导致读协程或协程池的协程退出
+func safeExec(tag uint16, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("handler of tag[%d] panic: %v\n%s", tag, r, debug.Stack())
+			err = fmt.Errorf("handler of tag[%d] panic: %v", tag, r)
+		}
+	}()
+	return fn()
+}
@@ -1,10 +1,12 @@
 package task
 
 import (
-	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 
+	"github.com/Meha555/pulse/utils"
+
 	"github.com/Meha555/go-tinylog"
 	"github.com/google/uuid"
 )
@@ -89,11 +91,14 @@ type Task struct {
 	repeat     int
 	status     Status
 	createTime time.Time
-	ctx        context.Context
-	cancel     context.CancelFunc
-	doneCh     chan struct{}
-	lastErr    error
-	pool       *WorkerPool
+	// 超时时间，零值表示没有超时
+	deadline time.Time
+	// 时间轮上的超时定时器，到期后任务视为已超时
+	timer    *utils.Timer
+	timedOut atomic.Bool
+	doneCh   chan struct{}
+	lastErr  error
+	pool     *WorkerPool
 }
 
 type option func(*Task)
@@ -114,7 +119,10 @@ func WithRepeat(repeat int) option {
 // 因为这种既有超时也有重复的场景往往是：意图在有限时间内多次重试
 func WithTimeout(timeout time.Duration) option {
 	return func(t *Task) {
-		t.ctx, t.cancel = context.WithTimeout(context.Background(), timeout)
+		t.deadline = time.Now().Add(timeout)
+		t.timer = utils.AfterFunc(timeout, func() {
+			t.timedOut.Store(true)
+		})
 	}
 }
 
@@ -141,8 +149,8 @@ func NewTask(id uuid.UUID, fn TaskHandlerFunc, opts ...option) *Task {
 	// 提供一个闭包函数
 	t.fn = func() {
 		defer func() {
-			if t.cancel != nil {
-				t.cancel()
+			if t.timer != nil {
+				t.timer.Stop()
 			}
 			if err := recover(); err != nil {
 				logger.Errorf("Task %s panic: %v", t.id, err)
@@ -151,11 +159,9 @@ func NewTask(id uuid.UUID, fn TaskHandlerFunc, opts ...option) *Task {
 				close(t.doneCh)
 			}
 		}()
-		if t.ctx != nil {
-			if deadline, ok := t.ctx.Deadline(); ok && time.Now().After(deadline) {
-				t.status = TaskStatusCanceled
-				return
-			}
+		if t.expired() {
+			t.status = TaskStatusCanceled
+			return
 		}
 		if t.status == TaskStatusCanceled {
 			t.doneCh <- struct{}{}
@@ -188,10 +194,8 @@ func (t *Task) Err() error {
 }
 
 func (t *Task) Exec() {
-	if t.ctx != nil {
-		if deadline, ok := t.ctx.Deadline(); ok && time.Now().After(deadline) {
-			t.status = TaskStatusCanceled
-		}
+	if t.expired() {
+		t.status = TaskStatusCanceled
 	}
 	if t.status == TaskStatusCanceled || t.status == TaskStatusFinished || t.status == TaskStatusFailed {
 		t.doneCh <- struct{}{}
@@ -223,7 +227,15 @@ func (t *Task) CreateTime() time.Time {
 }
 
 func (t *Task) Deadline() (deadline time.Time, ok bool) {
-	return t.ctx.Deadline()
+	return t.deadline, !t.deadline.IsZero()
+}
+
+// expired 是否已经超时。时间轮的精度为一个刻度，刻度内的超时按当前时间判断
+func (t *Task) expired() bool {
+	if t.deadline.IsZero() {
+		return false
+	}
+	return t.timedOut.Load() || !time.Now().Before(t.deadline)
 }
 
 func (t *Task) Done() <-chan struct{} {
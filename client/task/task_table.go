@@ -20,7 +20,8 @@ type taskTable struct {
 	utils.Dict[uuid.UUID, ITask]
 	cleanInterval time.Duration
 
-	cleanCn chan struct{}
+	// 时间轮上的清理定时器，每次清理后重新设置
+	cleanTimer *utils.Timer
 }
 
 type taskTableOption func(*taskTable)
@@ -39,34 +40,27 @@ func newTaskTable(opts ...taskTableOption) *taskTable {
 		tbl.SetCapacity(kDefaultThreshold)
 	}
 
-	// cleaner
-	go func() {
-		cleanFunc := func() {
-			for val := range tbl.Iter() {
-				id, task := val.Key, val.Value
-				if task.CreateTime().Before(time.Now()) {
-					if task.Status() != TaskStatusRunning && task.Status() != TaskStatusCreated {
-						tbl.Delete(id)
-					}
-				} else {
-					logger.Warnf("There is a task overstay for more than %v", tbl.cleanInterval)
-				}
-			}
-		}
+	// cleaner，清理可能较慢，不在时间轮的协程中执行
+	tbl.cleanTimer = utils.AfterFunc(tbl.cleanInterval, func() {
+		go tbl.clean()
+	})
+
+	return tbl
+}
 
-		ticker := time.NewTicker(tbl.cleanInterval)
-		for {
-			select {
-			case <-tbl.cleanCn:
-				cleanFunc()
-				ticker.Reset(tbl.cleanInterval)
-			case <-ticker.C:
-				cleanFunc()
+// clean 删除已经结束的任务，之后重新计时
+func (tbl *taskTable) clean() {
+	for val := range tbl.Iter() {
+		id, task := val.Key, val.Value
+		if task.CreateTime().Before(time.Now()) {
+			if task.Status() != TaskStatusRunning && task.Status() != TaskStatusCreated {
+				tbl.Delete(id)
 			}
+		} else {
+			logger.Warnf("There is a task overstay for more than %v", tbl.cleanInterval)
 		}
-	}()
-
-	return tbl
+	}
+	tbl.cleanTimer.Reset(tbl.cleanInterval)
 }
 
 func WithCleanInterval(interval time.Duration) taskTableOption {
@@ -83,7 +77,8 @@ func WithCapacity(cap int) taskTableOption {
 
 func (tbl *taskTable) Add(id uuid.UUID, task *Task) (err error) {
 	if err = tbl.Store(id, task); err != nil {
-		tbl.cleanCn <- struct{}{}
+		// 已满，立即清理一次
+		go tbl.clean()
 	}
 	return
 }
@@ -0,0 +1,62 @@
+package codec
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec 消息体的编解码器，负责业务结构体与消息Body之间的转换
+type Codec interface {
+	// 编解码器的名字
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	// NOTE 和json.Unmarshal一样，v必须是指针
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSON 文本协议
+	JSON Codec = jsonCodec{}
+	// Binary 二进制协议，要求类型实现 encoding.BinaryMarshaler 和 encoding.BinaryUnmarshaler
+	Binary Codec = binaryCodec{}
+)
+
+// Default 默认使用的编解码器
+var Default = JSON
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("%T does not implement encoding.BinaryMarshaler", v)
+	}
+	return m.MarshalBinary()
+}
+
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	u, ok := v.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%T does not implement encoding.BinaryUnmarshaler", v)
+	}
+	return u.UnmarshalBinary(data)
+}
@@ -0,0 +1,90 @@
+// Package topic 发布订阅的主题匹配与编码。
+// 主题由"."分隔的若干段组成，如"chat.room1.msg"。订阅时可以使用通配符：
+// "*"匹配恰好一段，"#"只能作为最后一段，匹配零或多段
+package topic
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	Separator      = "."
+	SingleWildcard = "*"
+	MultiWildcard  = "#"
+)
+
+var (
+	ErrInvalidTopic   = errors.New("invalid topic")
+	ErrInvalidPattern = errors.New("invalid topic pattern")
+)
+
+// ValidateTopic 检查发布的主题，主题不能包含通配符和空段
+func ValidateTopic(topic string) error {
+	if topic == "" || len(topic) > math.MaxUint16 {
+		return fmt.Errorf("%w: %q", ErrInvalidTopic, topic)
+	}
+	for _, seg := range strings.Split(topic, Separator) {
+		if seg == "" || strings.ContainsAny(seg, SingleWildcard+MultiWildcard) {
+			return fmt.Errorf("%w: %q", ErrInvalidTopic, topic)
+		}
+	}
+	return nil
+}
+
+// ValidatePattern 检查订阅的主题，通配符必须独占一段，"#"只能是最后一段
+func ValidatePattern(pattern string) error {
+	if pattern == "" || len(pattern) > math.MaxUint16 {
+		return fmt.Errorf("%w: %q", ErrInvalidPattern, pattern)
+	}
+	segs := strings.Split(pattern, Separator)
+	for i, seg := range segs {
+		switch {
+		case seg == "":
+			return fmt.Errorf("%w: %q", ErrInvalidPattern, pattern)
+		case seg == MultiWildcard && i != len(segs)-1:
+			return fmt.Errorf("%w: %q", ErrInvalidPattern, pattern)
+		case seg != SingleWildcard && seg != MultiWildcard && strings.ContainsAny(seg, SingleWildcard+MultiWildcard):
+			return fmt.Errorf("%w: %q", ErrInvalidPattern, pattern)
+		}
+	}
+	return nil
+}
+
+// Match 判断主题是否匹配订阅的pattern
+func Match(pattern, topic string) bool {
+	ps := strings.Split(pattern, Separator)
+	ts := strings.Split(topic, Separator)
+	for i, p := range ps {
+		if p == MultiWildcard {
+			return true
+		}
+		if i >= len(ts) || (p != SingleWildcard && p != ts[i]) {
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
+
+// Encode 编码发布的消息：2字节的主题长度 + 主题 + 负载
+func Encode(topic string, payload []byte) []byte {
+	data := make([]byte, 0, 2+len(topic)+len(payload))
+	data = binary.NativeEndian.AppendUint16(data, uint16(len(topic)))
+	data = append(data, topic...)
+	return append(data, payload...)
+}
+
+// Decode 解析 Encode 编码的消息
+func Decode(data []byte) (topic string, payload []byte, err error) {
+	if len(data) < 2 {
+		return "", nil, errors.New("data length is less than topic header size")
+	}
+	n := int(binary.NativeEndian.Uint16(data))
+	if len(data) < 2+n {
+		return "", nil, errors.New("data length is less than topic size")
+	}
+	return string(data[2 : 2+n]), data[2+n:], nil
+}
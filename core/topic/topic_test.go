@@ -0,0 +1,52 @@
+package topic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"chat.room1", "chat.room1", true},
+		{"chat.room1", "chat.room2", false},
+		{"chat.*", "chat.room1", true},
+		{"chat.*", "chat.room1.msg", false},
+		{"chat.*.msg", "chat.room1.msg", true},
+		{"chat.#", "chat", true},
+		{"chat.#", "chat.room1.msg", true},
+		{"#", "chat.room1", true},
+		{"chat.room1.msg", "chat.room1", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Match(tt.pattern, tt.topic), "%s ~ %s", tt.pattern, tt.topic)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, pattern := range []string{"a", "a.*", "a.*.b", "a.#", "#"} {
+		assert.NoError(t, ValidatePattern(pattern), pattern)
+	}
+	for _, pattern := range []string{"", "a..b", "a.#.b", "a*", "a.b#"} {
+		assert.ErrorIs(t, ValidatePattern(pattern), ErrInvalidPattern, pattern)
+	}
+	assert.NoError(t, ValidateTopic("a.b"))
+	for _, topic := range []string{"", "a.*", "a.#", "a..b"} {
+		assert.ErrorIs(t, ValidateTopic(topic), ErrInvalidTopic, topic)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	topic, payload, err := Decode(Encode("chat.room1", []byte("hello")))
+	require.NoError(t, err)
+	assert.Equal(t, "chat.room1", topic)
+	assert.Equal(t, "hello", string(payload))
+
+	_, _, err = Decode([]byte{10, 0, 'a'})
+	assert.Error(t, err)
+}
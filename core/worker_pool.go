@@ -1,6 +1,8 @@
 package core
 
 import (
+	"fmt"
+	"runtime/debug"
 	"sync"
 
 	"github.com/Meha555/go-tinylog"
@@ -18,7 +20,8 @@ func init() {
 }
 
 type Processer[Handler any] interface {
-	// Process 执行处理逻辑。需要实现者在其中处理panic，否则协程会退出
+	// Process 执行处理逻辑。
+	// 即使实现者没有处理panic，WorkerPool也会兜底recover，保证worker协程不会因此退出
 	Process(Handler) error
 }
 
@@ -56,7 +59,7 @@ func (w *WorkerPool[Handler]) Start() {
 				default:
 					logger.Debugf("Worker[%d] processing request", workerID)
 					handler := w.mq.Pop()
-					if err := w.processer.Process(handler); err != nil {
+					if err := w.process(handler); err != nil {
 						logger.Errorf("Worker[%d] process request failed: %v", workerID, err)
 					}
 				}
@@ -65,6 +68,17 @@ func (w *WorkerPool[Handler]) Start() {
 	}
 }
 
+// process 调用processer处理handler，并将其中的panic转换为错误，避免panic导致整个进程崩溃
+func (w *WorkerPool[Handler]) process(handler Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("process panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("process panic: %v", r)
+		}
+	}()
+	return w.processer.Process(handler)
+}
+
 func (w *WorkerPool[Handler]) Stop() {
 	w.mq.Close()
 	close(w.stopCh)
@@ -91,15 +91,15 @@ import (
 )
 
 func main() {
-	cli := client.NewClient("127.0.0.1", 3333, client.WithExitTimeout(5), client.WithHeartBeatInterval(1))
+	cli := client.NewClient("127.0.0.1", 3333, client.WithExitTimeout(5*time.Second), client.WithHeartBeatInterval(1))
 	cli.Start(context.Background(),
-		func() { doEcho(cli, 0) },
-		func() { doEcho(cli, 1) })
+		func(ctx context.Context) { doEcho(ctx, cli, 0) },
+		func(ctx context.Context) { doEcho(ctx, cli, 1) })
 }
 
-func doEcho(cli *client.Client, id uint16) {
+func doEcho(ctx context.Context, cli *client.Client, id uint16) {
 	var serial uint32 = 0
-	for {
+	for ctx.Err() == nil {
 		msgSent := message.NewSeqedTLVMsg(serial, id, fmt.Appendf(nil, "hello github.com/Meha555/pulse [%d]", id))
 		if err := cli.SendMsg(msgSent); err != nil {
 			Log.Errorf("Write error: %v", err)
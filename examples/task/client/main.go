@@ -10,8 +10,6 @@ import (
 	. "github.com/Meha555/go-tinylog"
 	"github.com/Meha555/pulse/client"
 	tasking "github.com/Meha555/pulse/client/task"
-	"github.com/Meha555/pulse/core/message"
-	"github.com/Meha555/pulse/server/job"
 	"github.com/Meha555/pulse/utils"
 	"github.com/google/uuid"
 )
@@ -21,48 +19,20 @@ var pool = tasking.NewWorkerPool(3, mq)
 
 func main() {
 	pool.Start()
-	cli := client.NewClient("127.0.0.1", 3333, client.WithExitTimeout(5), client.WithHeartBeatInterval(1))
-	cli.Start(context.Background(), // NOTE 这里的多个func，实际上应该是作为线程池执行的任务，而不是直接作为一个线程
-		func() { doReceive(cli) },
-		func() { doCaculate(cli, task.AddJobTag) },
-		func() { doCaculate(cli, task.SubJobTag) },
-		func() { doCaculate(cli, task.MulJobTag) },
-		func() { doCaculate(cli, task.DivJobTag) })
+	cli := client.NewClient("127.0.0.1", 3333, client.WithExitTimeout(5*time.Second), client.WithHeartBeatInterval(1), client.WithCallTimeout(5*time.Second))
+	if err := cli.Start(context.Background(), // NOTE 这里的多个func，实际上应该是作为线程池执行的任务，而不是直接作为一个线程
+		func(ctx context.Context) { doCaculate(ctx, cli, task.AddJobTag) },
+		func(ctx context.Context) { doCaculate(ctx, cli, task.SubJobTag) },
+		func(ctx context.Context) { doCaculate(ctx, cli, task.MulJobTag) },
+		func(ctx context.Context) { doCaculate(ctx, cli, task.DivJobTag) }); err != nil {
+		Log.Errorf("Client exit with error: %v", err)
+	}
 	pool.Stop()
 	Log.Info("Client exit")
 }
 
-func doReceive(cli *client.Client) {
-	for {
-		msg := &message.SeqedTLVMsg{}
-		if err := cli.RecvMsg(msg); err != nil {
-			Log.Errorf("worker read buf error: %v", err)
-			return
-		}
-		// 丢弃无关的心跳包
-		if msg.Tag() == job.HeartBeatTag {
-			continue
-		}
-
-		var rsp task.Response
-		if err := rsp.UnmarshalBinary(msg.Body()); err != nil {
-			Log.Errorf("worker unmarshal data error: %v", err)
-			continue
-		}
-
-		task, exists := tasking.TaskTbl.Load(rsp.ID)
-		if !exists {
-			Log.Errorf("task not found: %v", rsp.ID)
-			continue
-		}
-		task.AppendData(rsp)
-		task.Exec()
-	}
-}
-
-func doCaculate(cli *client.Client, kind uint16) {
-	var serial uint32 = 0
-	for {
+func doCaculate(ctx context.Context, cli *client.Client, kind uint16) {
+	for ctx.Err() == nil {
 		taskID := uuid.New()
 		A := rand.Uint32N(100)
 		B := rand.Uint32N(100)
@@ -83,15 +53,11 @@ func doCaculate(cli *client.Client, kind uint16) {
 			Log.Errorf("write arg(%+v) failed: %v", arg, err)
 			continue
 		}
-		msgSent := message.NewSeqedTLVMsg(serial, kind, buf)
-		if err = cli.SendMsg(msgSent); err != nil {
-			Log.Errorf("Write error: %v", err)
-			return
-		}
+		// 响应通过序列号与请求对应，不再需要自己维护接收循环和任务表
+		future := cli.Go(ctx, kind, buf)
 		Log.Infof("Send A = %d, B = %d, kind = %c", A, B, task.KindStr[kind])
-		serial++
 
-		tasking.NewTask(taskID, func(t *tasking.Task) error {
+		t := tasking.NewTask(taskID, func(t *tasking.Task) error {
 			if len(t.Data()) == 0 {
 				return errors.New("task must have data")
 			}
@@ -102,6 +68,24 @@ func doCaculate(cli *client.Client, kind uint16) {
 			return nil
 		}, tasking.WithWorkerPool(pool), tasking.WithData(A, B))
 
-		time.Sleep(time.Duration(3) * time.Second)
+		go func() {
+			reply, err := future.Result()
+			if err != nil {
+				Log.Errorf("call %c failed: %v", task.KindStr[kind], err)
+				return
+			}
+			var rsp task.Response
+			if err := rsp.UnmarshalBinary(reply.Body); err != nil {
+				Log.Errorf("worker unmarshal data error: %v", err)
+				return
+			}
+			t.AppendData(rsp)
+			t.Exec()
+		}()
+
+		select {
+		case <-time.After(3 * time.Second):
+		case <-ctx.Done():
+		}
 	}
 }
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/Meha555/pulse/server/common"
+)
+
+// 认证流程：
+// 1. 连接建立后，会话在开始路由请求之前调用 Authenticator，期间只处理 job.AuthTag 消息
+// 2. 每一轮都由客户端先发送认证消息，服务端在需要时回复挑战
+// 3. 认证成功后服务端以 job.AuthTag 回复用户ID，失败或超时则以 job.ErrorTag 回复后断开连接
+
+var (
+	ErrAuthFailed  = errors.New("authentication failed")
+	ErrAuthTimeout = errors.New("authentication timeout")
+)
+
+// Transport 认证期间与对端交换 job.AuthTag 消息
+type Transport interface {
+	// 底层连接，开启TLS时为 *tls.Conn
+	Conn() net.Conn
+	// 读取对端的下一条认证消息
+	Recv() ([]byte, error)
+	// 向对端发送认证消息，如挑战
+	Send(data []byte) error
+}
+
+// Authenticator 在会话开始路由请求之前认证对端，返回对端的身份
+type Authenticator interface {
+	Authenticate(t Transport) (*common.Principal, error)
+}
+
+// AuthenticatorFunc 函数形式的 Authenticator
+type AuthenticatorFunc func(t Transport) (*common.Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(t Transport) (*common.Principal, error) {
+	return f(t)
+}
+
+// Token 令牌认证：客户端发送令牌，由verify校验并返回对应的身份
+func Token(verify func(token string) (*common.Principal, error)) Authenticator {
+	return AuthenticatorFunc(func(t Transport) (*common.Principal, error) {
+		token, err := t.Recv()
+		if err != nil {
+			return nil, err
+		}
+		principal, err := verify(string(token))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrAuthFailed, err)
+		}
+		return principal, nil
+	})
+}
+
+// StaticTokens 使用固定的<令牌, 身份>表做令牌认证
+func StaticTokens(tokens map[string]*common.Principal) Authenticator {
+	return Token(func(token string) (*common.Principal, error) {
+		principal, ok := tokens[token]
+		if !ok {
+			return nil, errors.New("invalid token")
+		}
+		return principal, nil
+	})
+}
+
+// TLSCert TLS客户端证书认证：客户端发送一条空的认证消息，身份由已验证的客户端证书确定。
+// 服务端的 tls.Config 需要设置 ClientAuth 为 tls.RequireAndVerifyClientCert。
+// mapper为nil时以证书的CommonName为用户ID，OrganizationalUnit为角色
+func TLSCert(mapper func(cert *x509.Certificate) (*common.Principal, error)) Authenticator {
+	if mapper == nil {
+		mapper = func(cert *x509.Certificate) (*common.Principal, error) {
+			return &common.Principal{UserID: cert.Subject.CommonName, Roles: cert.Subject.OrganizationalUnit}, nil
+		}
+	}
+	return AuthenticatorFunc(func(t Transport) (*common.Principal, error) {
+		if _, err := t.Recv(); err != nil {
+			return nil, err
+		}
+		conn, ok := t.Conn().(*tls.Conn)
+		if !ok {
+			return nil, fmt.Errorf("%w: connection is not tls", ErrAuthFailed)
+		}
+		state := conn.ConnectionState()
+		if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+			return nil, fmt.Errorf("%w: no verified client certificate", ErrAuthFailed)
+		}
+		principal, err := mapper(state.PeerCertificates[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrAuthFailed, err)
+		}
+		return principal, nil
+	})
+}
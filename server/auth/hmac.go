@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/Meha555/pulse/server/common"
+)
+
+// 挑战的长度
+const kChallengeSize = 32
+
+// HMAC 挑战-应答认证，密钥不会在连接上传输：
+// 1. 客户端发送用户ID
+// 2. 服务端回复随机挑战
+// 3. 客户端回复 Sign(密钥, 挑战)
+// lookup根据用户ID返回密钥和身份
+func HMAC(lookup func(userID string) (secret []byte, principal *common.Principal, err error)) Authenticator {
+	return AuthenticatorFunc(func(t Transport) (*common.Principal, error) {
+		userID, err := t.Recv()
+		if err != nil {
+			return nil, err
+		}
+		secret, principal, lookupErr := lookup(string(userID))
+		if lookupErr != nil {
+			// 未知用户同样下发挑战并用随机密钥校验，对端无法据此区分用户是否存在
+			secret = make([]byte, kChallengeSize)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+		}
+		challenge := make([]byte, kChallengeSize)
+		if _, err := rand.Read(challenge); err != nil {
+			return nil, err
+		}
+		if err := t.Send(challenge); err != nil {
+			return nil, err
+		}
+		mac, err := t.Recv()
+		if err != nil {
+			return nil, err
+		}
+		matched := hmac.Equal(mac, Sign(secret, challenge))
+		if lookupErr != nil {
+			return nil, fmt.Errorf("%w: %v", ErrAuthFailed, lookupErr)
+		}
+		if !matched {
+			return nil, fmt.Errorf("%w: signature mismatch", ErrAuthFailed)
+		}
+		return principal, nil
+	})
+}
+
+// Sign 用密钥对挑战做HMAC-SHA256签名
+func Sign(secret, challenge []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(challenge)
+	return h.Sum(nil)
+}
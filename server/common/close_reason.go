@@ -0,0 +1,62 @@
+package common
+
+// CloseReason 会话关闭的原因，也是关闭消息中通知对端的关闭码
+type CloseReason int32
+
+const (
+	// 会话尚未关闭
+	CloseNone CloseReason = iota
+	// 服务端主动关闭
+	CloseNormal
+	// 对端关闭了连接
+	ClosePeerClosed
+	// 读超时：conn_timeout内没有收到任何数据
+	CloseReadTimeout
+	// 写超时：对端长时间不读取，write_timeout内没能发出消息
+	CloseWriteTimeout
+	// 空闲超时：idle_timeout内没有业务消息
+	CloseIdleTimeout
+	// 心跳超时：连续多次心跳没有回应
+	CloseHeartbeatTimeout
+	// 被踢出
+	CloseKicked
+	// 认证失败或超时
+	CloseAuthFailed
+	// 等待会话恢复超时
+	CloseResumeTimeout
+	// 读写出错
+	CloseError
+	// 对端违反协议，如消息长度超过限制
+	CloseProtocolError
+	// 服务端停机
+	CloseShutdown
+	// 发送队列溢出：对端读取太慢，消息积压超过限制
+	CloseSlowConsumer
+	// 连接已移交给被恢复的会话，仅用于恢复时临时创建的会话
+	CloseResumed
+)
+
+var closeReasonNames = [...]string{
+	CloseNone:             "none",
+	CloseNormal:           "normal",
+	ClosePeerClosed:       "peer closed",
+	CloseReadTimeout:      "read timeout",
+	CloseWriteTimeout:     "write timeout",
+	CloseIdleTimeout:      "idle timeout",
+	CloseHeartbeatTimeout: "heartbeat timeout",
+	CloseKicked:           "kicked",
+	CloseAuthFailed:       "auth failed",
+	CloseResumeTimeout:    "resume timeout",
+	CloseError:            "error",
+	CloseProtocolError:    "protocol error",
+	CloseShutdown:         "shutdown",
+	CloseSlowConsumer:     "slow consumer",
+	CloseResumed:          "resumed",
+}
+
+func (r CloseReason) String() string {
+	if r < 0 || int(r) >= len(closeReasonNames) {
+		return "unknown"
+	}
+	return closeReasonNames[r]
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// HeartBeatInitiator 主动发送心跳的一端，另一端收到后回应
+type HeartBeatInitiator int
+
+const (
+	// 服务端发送心跳，客户端回应
+	HeartBeatByServer HeartBeatInitiator = iota
+	// 客户端发送心跳，服务端回应
+	HeartBeatByClient
+	// 双方都发送心跳
+	HeartBeatByBoth
+)
+
+var heartBeatInitiatorNames = [...]string{
+	HeartBeatByServer: "server",
+	HeartBeatByClient: "client",
+	HeartBeatByBoth:   "both",
+}
+
+func (i HeartBeatInitiator) String() string {
+	if i < 0 || int(i) >= len(heartBeatInitiatorNames) {
+		return "unknown"
+	}
+	return heartBeatInitiatorNames[i]
+}
+
+// ParseHeartBeatInitiator 解析配置中的 "server"、"client"、"both"
+func ParseHeartBeatInitiator(s string) (HeartBeatInitiator, error) {
+	for i, name := range heartBeatInitiatorNames {
+		if name == s {
+			return HeartBeatInitiator(i), nil
+		}
+	}
+	return HeartBeatByServer, fmt.Errorf("unknown heartbeat initiator %q", s)
+}
+
+// HeartBeatPolicy 心跳策略，服务端和客户端共用。
+// 每个间隔检查一次对端是否存活，需要时发出带时间戳的心跳，对端的回应用于计算RTT和时钟偏差
+type HeartBeatPolicy struct {
+	// 主动发送心跳的一端
+	Initiator HeartBeatInitiator
+	// 发送心跳和检查存活的间隔，0表示关闭心跳
+	Interval time.Duration
+	// 连续这么多个间隔没有收到对端的心跳或回应时判定连接已断开，0表示不检查
+	MaxMissed uint
+	// 为true时收到任何消息都视为对端存活，而不仅是心跳
+	AnyTraffic bool
+}
+
+// ServerSends 服务端是否主动发送心跳
+func (p HeartBeatPolicy) ServerSends() bool {
+	return p.Initiator == HeartBeatByServer || p.Initiator == HeartBeatByBoth
+}
+
+// ClientSends 客户端是否主动发送心跳
+func (p HeartBeatPolicy) ClientSends() bool {
+	return p.Initiator == HeartBeatByClient || p.Initiator == HeartBeatByBoth
+}
+
+// HeartBeatStats 心跳统计，RTT和时钟偏差在收到对端对本端心跳的回应后才有值
+type HeartBeatStats struct {
+	// 最近一次心跳的往返时间
+	RTT time.Duration
+	// 对端时钟减去本端时钟的估计值，假设往返的链路延迟对称
+	ClockSkew time.Duration
+	// 最近一次确认对端存活的时间
+	LastSeen time.Time
+	// 当前连续没有收到心跳的间隔数
+	Missed uint
+}
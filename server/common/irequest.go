@@ -11,6 +11,8 @@ type IRequest interface {
 	Session() ISession
 	// 获取请求数据
 	Msg() message.ISeqedTLVMsg
+	// 获取发起请求的对端身份，未认证时为nil
+	Principal() *Principal
 	// 设置传递的参数（上下文）
 	Set(key string, value interface{})
 	// 获取传递的参数（上下文）
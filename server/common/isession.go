@@ -13,8 +13,8 @@ import (
 type ISession interface {
 	// 让当前连接开始工作
 	Open() error
-	// 停止连接的工作，关闭连接
-	Close()
+	// 以指定的原因停止连接的工作，关闭连接，已经关闭时不会覆盖之前的原因
+	Close(reason CloseReason)
 	// 获取该对象的唯一标识
 	ID() uuid.UUID
 	// 获取底层的socket
@@ -23,12 +23,38 @@ type ISession interface {
 	UpdateHeartBeat()
 	// 获取心跳计次
 	HeartBeat() uint
+	// 获取心跳统计
+	HeartBeatStats() HeartBeatStats
 	// 获取可读写的退出chan
 	ExitChan() <-chan struct{}
+	// 获取认证通过的对端身份，服务端未配置认证时为nil
+	Principal() *Principal
+	// 获取会话的属性存储
+	Attrs() IAttributes
+	// 获取会话关闭的原因，未关闭时为 CloseNone
+	CloseReason() CloseReason
+	// 获取会话接受的最大消息体长度，0表示不限制
+	MaxPacketSize() uint32
 
 	SendMsg(msg message.IPacket) error
+	// 不阻塞地发送消息，发送队列已满时返回错误
+	TrySendMsg(msg message.IPacket) error
+	// 发送队列是否未达到高水位，为false时业务应暂停向该会话生产消息
+	Writable() bool
 	RecvMsg(msg message.IPacket) error
 }
 
+// IAttributes 会话的属性存储，可以被并发访问，生命周期与会话相同，会话关闭后被清空
+type IAttributes interface {
+	// 设置属性，会话关闭后设置无效
+	Set(key string, value any)
+	// 获取属性
+	Get(key string) (any, bool)
+	// 删除属性
+	Delete(key string)
+	// 遍历所有属性，fn返回false时停止
+	Range(fn func(key string, value any) bool)
+}
+
 // 所有Connection在处理业务时的钩子方法的函数签名
 // type HandleFunc func(peer *net.TCPConn, data []byte, cnt int) error
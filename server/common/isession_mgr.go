@@ -1,6 +1,10 @@
 package common
 
-import "github.com/google/uuid"
+import (
+	"github.com/Meha555/pulse/core/message"
+
+	"github.com/google/uuid"
+)
 
 // ISessionMgr 连接管理器接口
 // 通过连接管理器，可以统一管理连接（新建、删除、获取）
@@ -14,6 +18,26 @@ type ISessionMgr interface {
 	Get(connID uuid.UUID) ISession
 	// 当前连接个数
 	Count() uint
-	// 关闭所有连接并清空
+	// 以 CloseShutdown 关闭所有连接并清空
 	Clear()
+	// 遍历所有连接，fn返回false时停止
+	Range(fn func(session ISession) bool)
+	// 向所有连接发送消息，返回成功放入发送队列的连接个数
+	Broadcast(msg message.IPacket) (int, error)
+	// 向指定的连接发送消息
+	Multicast(ids []uuid.UUID, msg message.IPacket) (int, error)
+	// 向filter返回true的连接发送消息
+	SendIf(msg message.IPacket, filter func(session ISession) bool) (int, error)
+	// 发送携带原因的关闭消息后断开指定的连接
+	Kick(connID uuid.UUID, reason string) error
+	// 发送携带关闭码和说明的关闭消息后以reason关闭指定的连接
+	Disconnect(connID uuid.UUID, reason CloseReason, message string) error
+	// 查找属性key的值等于value的连接
+	Lookup(key string, value any) []ISession
+	// 将连接与业务的key（如用户ID、设备ID）绑定
+	Bind(conn ISession, key string) error
+	// 解除连接与key的绑定
+	Unbind(connID uuid.UUID, key string)
+	// 获取与key绑定的连接
+	GetByKey(key string) []ISession
 }
@@ -0,0 +1,22 @@
+package common
+
+// Principal 认证通过的对端身份
+type Principal struct {
+	// 用户的唯一标识
+	UserID string
+	// 用户拥有的角色，用于路由的授权检查
+	Roles []string
+}
+
+// HasRole 判断是否拥有指定的角色
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
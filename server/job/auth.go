@@ -0,0 +1,53 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Meha555/pulse/server/common"
+)
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+)
+
+// RequireAuth 要求请求方已经通过认证，否则以ErrorTag回复 ErrUnauthenticated
+func RequireAuth() Middleware {
+	return Authorize(func(principal *common.Principal, tag uint16) error {
+		if principal == nil {
+			return ErrUnauthenticated
+		}
+		return nil
+	})
+}
+
+// RequireRoles 要求请求方通过认证并拥有roles中的任意一个角色，否则以ErrorTag回复
+func RequireRoles(roles ...string) Middleware {
+	return Authorize(func(principal *common.Principal, tag uint16) error {
+		if principal == nil {
+			return ErrUnauthenticated
+		}
+		for _, role := range roles {
+			if principal.HasRole(role) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: user %s has none of roles %v", ErrForbidden, principal.UserID, roles)
+	})
+}
+
+// Authorize 以自定义规则做授权检查，rule返回错误时拒绝请求并以ErrorTag回复该错误
+func Authorize(rule func(principal *common.Principal, tag uint16) error) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(tag uint16, req common.IRequest) error {
+			if err := rule(req.Principal(), tag); err != nil {
+				if replyErr := ReplyError(req, err); replyErr != nil {
+					logger.Errorf("reply auth error of tag[%d] failed: %v", tag, replyErr)
+				}
+				return err
+			}
+			return next(tag, req)
+		}
+	}
+}
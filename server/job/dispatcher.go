@@ -0,0 +1,83 @@
+package job
+
+import (
+	"hash/maphash"
+
+	"github.com/Meha555/pulse/core"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/utils"
+)
+
+// Dispatcher 将会话收到的请求交给业务协程处理
+// - WorkerPool 所有请求完全并行，同一个连接的相邻请求可能在不同的协程上乱序执行
+// - ShardedPool 按key分片，同一个key的请求按到达顺序依次执行，不同key的请求并行执行
+type Dispatcher interface {
+	Start()
+	Stop()
+	Post(request common.IRequest)
+}
+
+var (
+	_ Dispatcher = (*WorkerPool)(nil)
+	_ Dispatcher = (*ShardedPool)(nil)
+)
+
+// ShardKey 计算请求的分片key，key相同的请求保证按顺序执行
+type ShardKey func(request common.IRequest) string
+
+// BySession 按会话分片，同一个连接的请求按顺序执行
+func BySession(request common.IRequest) string {
+	id := request.Session().ID()
+	return string(id[:])
+}
+
+// ShardedPool 分片的业务协程池，每个分片只有一个协程，串行执行分到该分片的请求。
+// 耗时的请求会阻塞同一分片上的后续请求（包括其它key的请求），分片数应明显多于预期的耗时请求并发数
+type ShardedPool struct {
+	shards []*core.WorkerPool[common.IRequest]
+	key    ShardKey
+	seed   maphash.Seed
+}
+
+// NewShardedPool 创建shards个分片，每个分片的队列容量为queueSize。key为nil时按会话分片
+func NewShardedPool(shards, queueSize int, router IJobRouter, key ShardKey) *ShardedPool {
+	if shards <= 0 {
+		shards = 1
+	}
+	if key == nil {
+		key = BySession
+	}
+	p := &ShardedPool{
+		shards: make([]*core.WorkerPool[common.IRequest], shards),
+		key:    key,
+		seed:   maphash.MakeSeed(),
+	}
+	processer := &JobProcesser{router: router}
+	for i := range p.shards {
+		p.shards[i] = core.NewWorkerPool(1, utils.NewBlockingQueue[common.IRequest](queueSize), processer)
+	}
+	return p
+}
+
+func (p *ShardedPool) Start() {
+	for _, shard := range p.shards {
+		shard.Start()
+	}
+}
+
+func (p *ShardedPool) Stop() {
+	for _, shard := range p.shards {
+		shard.Stop()
+	}
+}
+
+// Post 将请求放入key对应分片的队列，队列已满时阻塞
+func (p *ShardedPool) Post(request common.IRequest) {
+	p.shardOf(request).Post(request)
+}
+
+// shardOf 返回请求所在的分片
+func (p *ShardedPool) shardOf(request common.IRequest) *core.WorkerPool[common.IRequest] {
+	h := maphash.String(p.seed, p.key(request))
+	return p.shards[h%uint64(len(p.shards))]
+}
@@ -0,0 +1,90 @@
+package job
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type idSession struct {
+	common.ISession
+	id uuid.UUID
+}
+
+func (s *idSession) ID() uuid.UUID { return s.id }
+
+// seqRequest 带有会话内序号的请求
+type seqRequest struct {
+	common.IRequest
+	session *idSession
+	msg     message.ISeqedTLVMsg
+}
+
+func (r *seqRequest) Session() common.ISession  { return r.session }
+func (r *seqRequest) Msg() message.ISeqedTLVMsg { return r.msg }
+
+// recordRouter 按会话记录请求的执行顺序，并统计同时执行的请求数
+type recordRouter struct {
+	IJobRouter
+	mtx      sync.Mutex
+	order    map[uuid.UUID][]uint32
+	running  atomic.Int32
+	parallel atomic.Int32
+	wg       sync.WaitGroup
+}
+
+func (r *recordRouter) ExecJob(tag uint16, request common.IRequest) error {
+	defer r.wg.Done()
+	n := r.running.Add(1)
+	defer r.running.Add(-1)
+	for {
+		peak := r.parallel.Load()
+		if n <= peak || r.parallel.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	id := request.Session().ID()
+	r.order[id] = append(r.order[id], request.Msg().Serial())
+	return nil
+}
+
+func TestShardedPool(t *testing.T) {
+	router := &recordRouter{order: make(map[uuid.UUID][]uint32)}
+	pool := NewShardedPool(8, 4, router, nil)
+	// 同 WorkerPool，Stop后协程会取到关闭的队列中的零值，这里不停止
+	pool.Start()
+
+	const sessions, requests = 16, 20
+	ids := make([]*idSession, sessions)
+	for i := range ids {
+		ids[i] = &idSession{id: uuid.New()}
+	}
+	router.wg.Add(sessions * requests)
+	for seq := range requests {
+		for _, s := range ids {
+			pool.Post(&seqRequest{session: s, msg: message.NewSeqedTLVMsg(uint32(seq), 1, nil)})
+		}
+	}
+	router.wg.Wait()
+
+	// 同一会话的请求按顺序执行
+	want := make([]uint32, requests)
+	for i := range want {
+		want[i] = uint32(i)
+	}
+	for _, s := range ids {
+		assert.Equal(t, want, router.order[s.id])
+	}
+	// 不同会话的请求并行执行
+	assert.Greater(t, router.parallel.Load(), int32(1))
+}
@@ -0,0 +1,29 @@
+package job
+
+import (
+	"fmt"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+)
+
+// PanicError 业务回调发生panic后转换得到的错误
+type PanicError struct {
+	Tag   uint16
+	Value interface{}
+	// panic发生时的调用栈
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("job for tag[%d] panic: %v", e.Tag, e.Value)
+}
+
+// ReplyError 以ErrorTag向请求的来源会话回复一个错误，序列号与请求一致，便于对端对应到原请求
+func ReplyError(req common.IRequest, err error) error {
+	session := req.Session()
+	if session == nil {
+		return fmt.Errorf("reply error failed: request has no session")
+	}
+	return session.SendMsg(message.NewSeqedTLVMsg(req.Msg().Serial(), ErrorTag, []byte(err.Error())))
+}
@@ -0,0 +1,56 @@
+package job
+
+// RouteGroup 路由组
+// 组内注册的路由共享组的中间件，组可以嵌套，子组继承父组的中间件。
+// 执行顺序为：全局中间件 -> 父组中间件 -> 子组中间件 -> 路由中间件 -> job
+type RouteGroup struct {
+	router *JobRouter
+	parent *RouteGroup
+	mws    []Middleware
+}
+
+// middlewares 返回从最外层组到当前组的所有中间件，调用者需持有router.mtx
+func (g *RouteGroup) middlewares() []Middleware {
+	if g == nil {
+		return nil
+	}
+	return append(g.parent.middlewares(), g.mws...)
+}
+
+// Use 为组添加中间件，对组内已注册和之后注册的路由都生效
+func (g *RouteGroup) Use(mws ...Middleware) *RouteGroup {
+	g.router.mtx.Lock()
+	defer g.router.mtx.Unlock()
+	g.mws = append(g.mws, mws...)
+	g.router.recompose()
+	return g
+}
+
+// Group 创建子路由组
+func (g *RouteGroup) Group(mws ...Middleware) *RouteGroup {
+	return &RouteGroup{router: g.router, parent: g, mws: mws}
+}
+
+// Register 在组内注册一个路由，重复注册时返回错误
+func (g *RouteGroup) Register(tag uint16, job IJob, mws ...Middleware) error {
+	return g.router.register(&route{tags: TagRange{From: tag, To: tag}, job: job, group: g, mws: mws}, false)
+}
+
+// RegisterRange 在组内为闭区间[from, to]内的所有tag注册同一个job
+func (g *RouteGroup) RegisterRange(from, to uint16, job IJob, mws ...Middleware) error {
+	return g.router.register(&route{tags: TagRange{From: from, To: to}, job: job, group: g, mws: mws}, false)
+}
+
+func (g *RouteGroup) AddJob(tag uint16, job IJob, mws ...Middleware) *RouteGroup {
+	if err := g.Register(tag, job, mws...); err != nil {
+		logger.Errorf("add job failed: %v", err)
+	}
+	return g
+}
+
+func (g *RouteGroup) AddJobRange(from, to uint16, job IJob, mws ...Middleware) *RouteGroup {
+	if err := g.RegisterRange(from, to, job, mws...); err != nil {
+		logger.Errorf("add job range failed: %v", err)
+	}
+	return g
+}
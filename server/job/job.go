@@ -1,6 +1,8 @@
 package job
 
 import (
+	"time"
+
 	"github.com/Meha555/pulse/server/common"
 
 	"github.com/Meha555/go-tinylog"
@@ -40,8 +42,12 @@ type HeartBeatJob struct {
 	BaseJob
 }
 
+// Handle 处理心跳：更新心跳计次，需要时回应对端。
+// 框架的会话在读协程中直接处理心跳，不经过路由，这里用于自定义的 common.ISession 实现
 func (h *HeartBeatJob) Handle(req common.IRequest) error {
-	// 心跳包（客户端主动发送，只有包头没有包体）
 	req.Session().UpdateHeartBeat()
+	if ack := HeartBeatAck(req.Msg().Body(), time.Now()); ack != nil {
+		return reply(req, HeartBeatTag, ack)
+	}
 	return nil
 }
@@ -0,0 +1,76 @@
+package job
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/Meha555/pulse/server/common"
+)
+
+// HandlerFunc 路由最终执行的处理函数，中间件链的最内层就是对 IJob 三个回调的调用
+type HandlerFunc func(tag uint16, req common.IRequest) error
+
+// Middleware 中间件
+// 采用洋葱模型：每个中间件拿到下一层的 HandlerFunc，返回包装后的 HandlerFunc，
+// 因此可以在调用 next 前后做处理，并能看到 next 返回的错误
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// chain 将中间件按注册顺序组装起来，先注册的在最外层
+func chain(h HandlerFunc, mws ...Middleware) HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Recovery 捕获业务回调中的panic并转换为 *PanicError 返回，使外层中间件也能看到这个错误
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(tag uint16, req common.IRequest) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					stack := debug.Stack()
+					logger.Errorf("job for tag[%d] panic: %v\n%s", tag, r, stack)
+					err = &PanicError{Tag: tag, Value: r, Stack: stack}
+				}
+			}()
+			return next(tag, req)
+		}
+	}
+}
+
+// AccessLog 记录每个请求的来源、tag、序列号以及处理结果
+func AccessLog() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(tag uint16, req common.IRequest) error {
+			err := next(tag, req)
+			var peer string
+			if session := req.Session(); session != nil && session.Conn() != nil {
+				peer = session.Conn().RemoteAddr().String()
+			}
+			if err != nil {
+				logger.Warnf("[access] %s tag[%d] serial[%d] failed: %v", peer, tag, req.Msg().Serial(), err)
+			} else {
+				logger.Infof("[access] %s tag[%d] serial[%d] ok", peer, tag, req.Msg().Serial())
+			}
+			return err
+		}
+	}
+}
+
+// Timing 统计每个请求的处理耗时，并交给report上报。report为nil时直接打日志
+func Timing(report func(tag uint16, elapsed time.Duration, err error)) Middleware {
+	if report == nil {
+		report = func(tag uint16, elapsed time.Duration, err error) {
+			logger.Debugf("[timing] tag[%d] cost %v", tag, elapsed)
+		}
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(tag uint16, req common.IRequest) error {
+			start := time.Now()
+			err := next(tag, req)
+			report(tag, time.Since(start), err)
+			return err
+		}
+	}
+}
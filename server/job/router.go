@@ -1,18 +1,67 @@
 package job
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"runtime/debug"
+	"sort"
+	"sync"
 	_ "unsafe"
 
 	"github.com/Meha555/pulse/server/common"
 	"github.com/Meha555/pulse/utils"
 )
 
-// Api Tags
+// 系统tag区间
+// 0-99是给用户预留的自定义tag，100-199是框架保留的系统tag，用户路由不能注册在该区间，200及以上也可以自由使用
 const (
-	// 0-99是给用户预留的自定义tag
+	SystemTagMin uint16 = 100
+	SystemTagMax uint16 = 199
+)
 
+// Api Tags
+const (
+	// 心跳包，Body为空（只用于保活）、发起方的时间戳，或回应时发起方的时间戳+回应方的时间戳
 	HeartBeatTag = iota + 100
+	// 服务端处理请求出错时回复给客户端的错误通知，Body为错误信息
+	ErrorTag
+	// Ping请求，Body为发送方的时间戳，收到后回复PongTag并原样带回，用于测量RTT
+	PingTag
+	PongTag
+	// 查询服务端时间，回复的Body为服务端的时间戳
+	TimeTag
+	// 查询服务端支持的协议能力，回复的Body为json格式的 Capabilities
+	CapabilitiesTag
+	// 客户端请求优雅关闭，服务端回复CloseTag确认后由客户端主动断开。
+	// 服务端主动关闭连接前也会先发出CloseTag，Body为 EncodeClose 编码的关闭码和说明
+	CloseTag
+	// 开启会话恢复时，服务端在连接建立后下发的握手消息，Body为会话ID和恢复令牌
+	HandshakeTag
+	// 客户端重连后发送的会话恢复请求，Body为之前收到的恢复令牌，成功时服务端以ResumeTag回复，Body同HandshakeTag
+	ResumeTag
+	// 订阅主题，Body为主题（可以包含通配符），成功时以同tag回复
+	SubscribeTag
+	// 取消订阅，Body同SubscribeTag
+	UnsubscribeTag
+	// 客户端发布消息，Body为 topic.Encode 编码的主题和负载，成功时以同tag回复
+	PublishTag
+	// 服务端向订阅者投递的消息，Body同PublishTag
+	DeliverTag
+	// 服务端配置了认证时，连接建立后双方交换的认证消息，Body由认证方式决定。
+	// 认证成功时服务端以AuthTag回复，Body为用户ID；失败时以ErrorTag回复后断开连接
+	AuthTag
+)
+
+// IsSystemTag 判断tag是否落在框架保留的系统tag区间
+func IsSystemTag(tag uint16) bool {
+	return SystemTagMin <= tag && tag <= SystemTagMax
+}
+
+var (
+	ErrDuplicateRoute = errors.New("duplicate route")
+	ErrInvalidRange   = errors.New("invalid tag range")
+	ErrReservedTag    = errors.New("tag is reserved for system")
 )
 
 // IJobRouter
@@ -20,56 +69,313 @@ const (
 type IJobRouter interface {
 	// 从管理器中获取指定的路由
 	GetJob(tag uint16) IJob
-	// 往管理器中添加一个路由
-	AddJob(tag uint16, job IJob) IJobRouter
+	// 往管理器中添加一个路由，mws为仅作用于该路由的中间件
+	AddJob(tag uint16, job IJob, mws ...Middleware) IJobRouter
+	// 添加作用于所有路由的全局中间件
+	Use(mws ...Middleware) IJobRouter
 	// 执行指定的路由回调
 	ExecJob(tag uint16, request common.IRequest) error
 }
 
+// TagRange tag的闭区间[From, To]
+type TagRange struct {
+	From uint16
+	To   uint16
+}
+
+func (t TagRange) Contains(tag uint16) bool {
+	return t.From <= tag && tag <= t.To
+}
+
+func (t TagRange) Overlaps(other TagRange) bool {
+	return t.From <= other.To && other.From <= t.To
+}
+
+func (t TagRange) String() string {
+	if t.From == t.To {
+		return fmt.Sprintf("%d", t.From)
+	}
+	return fmt.Sprintf("%d-%d", t.From, t.To)
+}
+
+// RouteInfo 已注册路由的信息，用于自省
+type RouteInfo struct {
+	Tags TagRange
+	Job  IJob
+}
+
+// route 一条路由：业务job、所属的路由组以及仅作用于它的中间件
+type route struct {
+	tags  TagRange
+	job   IJob
+	group *RouteGroup
+	mws   []Middleware
+	// 组装好的中间件链，注册和添加中间件时更新，执行时不再组装
+	handler HandlerFunc
+}
+
+// handle 依次调用job的三个回调，是中间件链的最内层
+func (rt *route) handle(tag uint16, req common.IRequest) error {
+	if err := rt.job.PreHandle(req); err != nil {
+		return fmt.Errorf("call PreHandle error: %w", err)
+	}
+	if err := rt.job.Handle(req); err != nil {
+		return fmt.Errorf("call Handle error: %w", err)
+	}
+	if err := rt.job.PostHandle(req); err != nil {
+		return fmt.Errorf("call PostHandle error: %w", err)
+	}
+	return nil
+}
+
 type JobRouter struct {
-	// <tag, job>映射表
-	apis *utils.Dict[uint16, IJob]
+	// <tag, route>映射表
+	apis *utils.Dict[uint16, *route]
+	// 按tag区间注册的路由，精确匹配失败时才会查找
+	ranges []*route
+	// 找不到路由时的处理函数，以及包裹了全局中间件后的处理函数
+	notFound        HandlerFunc
+	notFoundHandler HandlerFunc
+	// 全局中间件
+	mws []Middleware
+	// 各tag的job发生panic的次数，用于发现有问题的job
+	panics map[uint16]uint64
+	mtx    sync.RWMutex
 }
 
 func NewJobRouter() *JobRouter {
-	return &JobRouter{
-		apis: utils.NewDict[uint16, IJob](),
+	r := &JobRouter{
+		// 容量覆盖整个tag空间，避免路由数量受Dict默认容量限制
+		apis:            utils.NewDict(utils.WithCapacity[uint16, *route](math.MaxUint16 + 1)),
+		notFound:        replyNotFound,
+		notFoundHandler: replyNotFound,
+		panics:          make(map[uint16]uint64),
+	}
+	// 注册内置的系统路由
+	r.registerSystemJobs()
+	return r
+}
+
+// replyNotFound 默认的找不到路由的处理：以ErrorTag告知请求方
+func replyNotFound(tag uint16, req common.IRequest) error {
+	err := fmt.Errorf("no job for tag[%d]", tag)
+	if replyErr := ReplyError(req, err); replyErr != nil {
+		logger.Errorf("reply not found of tag[%d] failed: %v", tag, replyErr)
+	}
+	return err
+}
+
+// lookup 先精确匹配tag，再查找tag区间
+func (r *JobRouter) lookup(tag uint16) (*route, bool) {
+	if rt, ok := r.apis.Load(tag); ok {
+		return rt, true
 	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	for _, rt := range r.ranges {
+		if rt.tags.Contains(tag) {
+			return rt, true
+		}
+	}
+	return nil, false
 }
 
 func (r *JobRouter) GetJob(tag uint16) IJob {
-	job, ok := r.apis.Load(tag)
+	rt, ok := r.lookup(tag)
 	if !ok {
 		logger.Errorf("get job failed")
 		return nil
 	}
-	return job
+	return rt.job
+}
+
+// register 注册一条路由，tags与已有路由重叠时返回 ErrDuplicateRoute。
+// 非system路由不能落在系统tag区间
+func (r *JobRouter) register(rt *route, system bool) error {
+	if rt.tags.From > rt.tags.To {
+		return fmt.Errorf("%w: %s", ErrInvalidRange, rt.tags)
+	}
+	if !system && rt.tags.Overlaps(TagRange{From: SystemTagMin, To: SystemTagMax}) {
+		return fmt.Errorf("%w: tag[%s]", ErrReservedTag, rt.tags)
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, other := range r.ranges {
+		if other.tags.Overlaps(rt.tags) {
+			return fmt.Errorf("%w: tag[%s] overlaps tag[%s]", ErrDuplicateRoute, rt.tags, other.tags)
+		}
+	}
+	for tag := int(rt.tags.From); tag <= int(rt.tags.To); tag++ {
+		if _, ok := r.apis.Load(uint16(tag)); ok {
+			return fmt.Errorf("%w: tag[%d] already registered", ErrDuplicateRoute, tag)
+		}
+	}
+	r.compose(rt)
+	if rt.tags.From == rt.tags.To {
+		return r.apis.Store(rt.tags.From, rt)
+	}
+	r.ranges = append(r.ranges, rt)
+	return nil
 }
 
-func (r *JobRouter) AddJob(tag uint16, job IJob) IJobRouter {
-	r.apis.Store(tag, job)
+// compose 按 全局 -> 路由组 -> 路由 的顺序组装路由的中间件链，调用方需持有r.mtx
+func (r *JobRouter) compose(rt *route) {
+	mws := make([]Middleware, 0, len(r.mws)+len(rt.mws))
+	mws = append(mws, r.mws...)
+	mws = append(mws, rt.group.middlewares()...)
+	mws = append(mws, rt.mws...)
+	rt.handler = chain(rt.handle, mws...)
+}
+
+// recompose 中间件变化后重新组装所有路由的中间件链，调用方需持有r.mtx
+func (r *JobRouter) recompose() {
+	r.apis.Range(func(_ uint16, rt *route) bool {
+		r.compose(rt)
+		return true
+	})
+	for _, rt := range r.ranges {
+		r.compose(rt)
+	}
+	r.notFoundHandler = chain(r.notFound, r.mws...)
+}
+
+// Register 注册一个路由，重复注册或tag属于系统tag区间时返回错误
+func (r *JobRouter) Register(tag uint16, job IJob, mws ...Middleware) error {
+	return r.register(&route{tags: TagRange{From: tag, To: tag}, job: job, mws: mws}, false)
+}
+
+// RegisterRange 为闭区间[from, to]内的所有tag注册同一个job，与已有路由或系统tag区间重叠时返回错误
+func (r *JobRouter) RegisterRange(from, to uint16, job IJob, mws ...Middleware) error {
+	return r.register(&route{tags: TagRange{From: from, To: to}, job: job, mws: mws}, false)
+}
+
+// RegisterSystem 注册系统路由，tag必须属于系统tag区间。
+// 仅供框架内部扩展内置协议使用，业务代码应使用 Register
+func (r *JobRouter) RegisterSystem(tag uint16, job IJob, mws ...Middleware) error {
+	if !IsSystemTag(tag) {
+		return fmt.Errorf("tag[%d] is not a system tag", tag)
+	}
+	return r.register(&route{tags: TagRange{From: tag, To: tag}, job: job, mws: mws}, true)
+}
+
+func (r *JobRouter) AddJob(tag uint16, job IJob, mws ...Middleware) IJobRouter {
+	if err := r.Register(tag, job, mws...); err != nil {
+		logger.Errorf("add job failed: %v", err)
+	}
 	return r
 }
 
+// AddJobRange 同 RegisterRange，出错时只记录日志，便于链式调用
+func (r *JobRouter) AddJobRange(from, to uint16, job IJob, mws ...Middleware) IJobRouter {
+	if err := r.RegisterRange(from, to, job, mws...); err != nil {
+		logger.Errorf("add job range failed: %v", err)
+	}
+	return r
+}
+
+// Use 添加全局中间件，按添加顺序由外向内执行。全局中间件总是包裹在路由中间件的外层
+func (r *JobRouter) Use(mws ...Middleware) IJobRouter {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.mws = append(r.mws, mws...)
+	r.recompose()
+	return r
+}
+
+// SetNotFound 设置找不到路由时的处理函数，全局中间件同样会作用于它。
+// handler为nil时恢复默认行为：以ErrorTag回复请求方
+func (r *JobRouter) SetNotFound(handler HandlerFunc) IJobRouter {
+	if handler == nil {
+		handler = replyNotFound
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.notFound = handler
+	r.notFoundHandler = chain(handler, r.mws...)
+	return r
+}
+
+// Group 创建一个路由组，组内路由共享mws
+func (r *JobRouter) Group(mws ...Middleware) *RouteGroup {
+	return &RouteGroup{router: r, mws: mws}
+}
+
+// Tags 返回所有精确注册的tag（升序）
+func (r *JobRouter) Tags() []uint16 {
+	tags := make([]uint16, 0, r.apis.Size())
+	r.apis.Range(func(tag uint16, _ *route) bool {
+		tags = append(tags, tag)
+		return true
+	})
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	return tags
+}
+
+// Routes 返回所有已注册的路由（包括tag区间），按起始tag升序
+func (r *JobRouter) Routes() []RouteInfo {
+	var infos []RouteInfo
+	r.apis.Range(func(_ uint16, rt *route) bool {
+		infos = append(infos, RouteInfo{Tags: rt.tags, Job: rt.job})
+		return true
+	})
+	r.mtx.RLock()
+	for _, rt := range r.ranges {
+		infos = append(infos, RouteInfo{Tags: rt.tags, Job: rt.job})
+	}
+	r.mtx.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Tags.From < infos[j].Tags.From })
+	return infos
+}
+
 //go:linkname PutRequest github.com/Meha555/pulse/server/session.PutRequest
 func PutRequest(request common.IRequest)
 
-func (r *JobRouter) ExecJob(tag uint16, req common.IRequest) error {
+func (r *JobRouter) ExecJob(tag uint16, req common.IRequest) (err error) {
 	/*
 		回收Request的时候就一定的合适的时机吗？如果只执行路由操作的话是的，但是在Handle中又需要开启一个新的协程去完成某些业务的同时还需要请求中的上下文呢？或者说需要传递请求对象呢？这就会出现生命周期的问题。
 	*/
 	defer PutRequest(req)
-	if job, ok := r.apis.Load(tag); ok {
-		if err := job.PreHandle(req); err != nil {
-			return fmt.Errorf("call PreHandle error: %v", err)
+	// 兜底的panic处理：无论是否使用了Recovery中间件，panic都会被计数并以错误回复给请求方
+	defer func() {
+		if p := recover(); p != nil {
+			stack := debug.Stack()
+			logger.Errorf("job for tag[%d] panic: %v\n%s", tag, p, stack)
+			err = &PanicError{Tag: tag, Value: p, Stack: stack}
 		}
-		if err := job.Handle(req); err != nil {
-			return fmt.Errorf("call Handle error: %v", err)
+		var pe *PanicError
+		if errors.As(err, &pe) {
+			r.mtx.Lock()
+			r.panics[tag]++
+			r.mtx.Unlock()
+			if replyErr := ReplyError(req, fmt.Errorf("internal error in job for tag[%d]", tag)); replyErr != nil {
+				logger.Errorf("reply panic of tag[%d] failed: %v", tag, replyErr)
+			}
 		}
-		if err := job.PostHandle(req); err != nil {
-			return fmt.Errorf("call PostHandle error: %v", err)
-		}
-		return nil
+	}()
+	rt, ok := r.lookup(tag)
+	r.mtx.RLock()
+	handler := r.notFoundHandler
+	if ok {
+		handler = rt.handler
+	}
+	r.mtx.RUnlock()
+	return handler(tag, req)
+}
+
+// PanicCount 返回指定tag的job发生panic的次数
+func (r *JobRouter) PanicCount(tag uint16) uint64 {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.panics[tag]
+}
+
+// PanicCounts 返回所有发生过panic的tag及其次数
+func (r *JobRouter) PanicCounts() map[uint16]uint64 {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	counts := make(map[uint16]uint64, len(r.panics))
+	for tag, n := range r.panics {
+		counts[tag] = n
 	}
-	return fmt.Errorf("no job for tag[%d]", tag)
+	return counts
 }
@@ -0,0 +1,202 @@
+package job_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	. "github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/server/session"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRequest(tag uint16) common.IRequest {
+	return session.NewRequest(nil, message.NewSeqedTLVMsg(0, tag, nil))
+}
+
+type recordJob struct {
+	BaseJob
+	trace *[]string
+	err   error
+}
+
+func (j *recordJob) Handle(req common.IRequest) error {
+	*j.trace = append(*j.trace, "handle")
+	return j.err
+}
+
+func recordMiddleware(name string, trace *[]string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(tag uint16, req common.IRequest) error {
+			*trace = append(*trace, name+">")
+			err := next(tag, req)
+			*trace = append(*trace, "<"+name)
+			return err
+		}
+	}
+}
+
+func TestJobRouter_Middleware(t *testing.T) {
+	t.Run("Onion order", func(t *testing.T) {
+		var trace []string
+		router := NewJobRouter()
+		router.Use(recordMiddleware("g1", &trace), recordMiddleware("g2", &trace))
+		router.AddJob(1, &recordJob{trace: &trace}, recordMiddleware("r", &trace))
+
+		assert.NoError(t, router.ExecJob(1, newRequest(1)))
+		assert.Equal(t, []string{"g1>", "g2>", "r>", "handle", "<r", "<g2", "<g1"}, trace)
+	})
+
+	t.Run("Error visible to middleware", func(t *testing.T) {
+		var trace []string
+		var seen error
+		expected := errors.New("mock error")
+		router := NewJobRouter()
+		router.Use(func(next HandlerFunc) HandlerFunc {
+			return func(tag uint16, req common.IRequest) error {
+				seen = next(tag, req)
+				return seen
+			}
+		})
+		router.AddJob(1, &recordJob{trace: &trace, err: expected})
+
+		err := router.ExecJob(1, newRequest(1))
+		assert.ErrorIs(t, seen, expected)
+		assert.Equal(t, seen, err)
+	})
+
+	t.Run("Composed once", func(t *testing.T) {
+		var trace []string
+		composed := 0
+		router := NewJobRouter()
+		group := router.Group()
+		group.AddJob(1, &recordJob{trace: &trace})
+		// 注册之后添加的全局和组中间件同样生效
+		router.Use(func(next HandlerFunc) HandlerFunc {
+			composed++
+			return next
+		})
+		group.Use(recordMiddleware("grp", &trace))
+
+		n := composed
+		for range 3 {
+			assert.NoError(t, router.ExecJob(1, newRequest(1)))
+		}
+		// 执行时不再组装中间件链
+		assert.Equal(t, n, composed)
+		assert.Equal(t, []string{"grp>", "handle", "<grp"}, trace[:3])
+	})
+
+	t.Run("Recovery", func(t *testing.T) {
+		router := NewJobRouter()
+		router.AddJob(1, &panicJob{}, Recovery())
+
+		err := router.ExecJob(1, newRequest(1))
+		var pe *PanicError
+		if assert.ErrorAs(t, err, &pe) {
+			assert.Equal(t, "mock panic", pe.Value)
+		}
+		assert.Equal(t, uint64(1), router.PanicCount(1))
+	})
+}
+
+func TestJobRouter_Panic(t *testing.T) {
+	router := NewJobRouter()
+	router.AddJob(1, &panicJob{})
+
+	assert.NotPanics(t, func() {
+		err := router.ExecJob(1, newRequest(1))
+		var pe *PanicError
+		if assert.ErrorAs(t, err, &pe) {
+			assert.Equal(t, uint16(1), pe.Tag)
+			assert.NotEmpty(t, pe.Stack)
+		}
+	})
+	router.ExecJob(1, newRequest(1))
+	assert.Equal(t, uint64(2), router.PanicCount(1))
+	assert.Equal(t, map[uint16]uint64{1: 2}, router.PanicCounts())
+}
+
+type panicJob struct {
+	BaseJob
+}
+
+func (j *panicJob) Handle(req common.IRequest) error {
+	panic("mock panic")
+}
+
+func TestJobRouter_Routes(t *testing.T) {
+	t.Run("Duplicate", func(t *testing.T) {
+		router := NewJobRouter()
+		assert.NoError(t, router.Register(1, &BaseJob{}))
+		assert.ErrorIs(t, router.Register(1, &BaseJob{}), ErrDuplicateRoute)
+		assert.NoError(t, router.RegisterRange(10, 19, &BaseJob{}))
+		assert.ErrorIs(t, router.Register(15, &BaseJob{}), ErrDuplicateRoute)
+		assert.ErrorIs(t, router.RegisterRange(0, 5, &BaseJob{}), ErrDuplicateRoute)
+		assert.ErrorIs(t, router.RegisterRange(19, 30, &BaseJob{}), ErrDuplicateRoute)
+		assert.ErrorIs(t, router.RegisterRange(30, 20, &BaseJob{}), ErrInvalidRange)
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		var trace []string
+		router := NewJobRouter()
+		router.AddJobRange(10, 19, &recordJob{trace: &trace})
+
+		assert.NoError(t, router.ExecJob(15, newRequest(15)))
+		assert.Equal(t, []string{"handle"}, trace)
+		assert.NotNil(t, router.GetJob(19))
+		assert.Nil(t, router.GetJob(20))
+	})
+
+	t.Run("Group", func(t *testing.T) {
+		var trace []string
+		router := NewJobRouter()
+		router.Use(recordMiddleware("g", &trace))
+		group := router.Group(recordMiddleware("a", &trace))
+		group.Group(recordMiddleware("b", &trace)).AddJob(1, &recordJob{trace: &trace})
+		group.Use(recordMiddleware("a2", &trace))
+
+		assert.NoError(t, router.ExecJob(1, newRequest(1)))
+		assert.Equal(t, []string{"g>", "a>", "a2>", "b>", "handle", "<b", "<a2", "<a", "<g"}, trace)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		var trace []string
+		router := NewJobRouter()
+		router.Use(recordMiddleware("g", &trace))
+		router.SetNotFound(func(tag uint16, req common.IRequest) error {
+			trace = append(trace, "not found")
+			return nil
+		})
+
+		assert.NoError(t, router.ExecJob(1, newRequest(1)))
+		assert.Equal(t, []string{"g>", "not found", "<g"}, trace)
+	})
+
+	t.Run("Introspection", func(t *testing.T) {
+		router := NewJobRouter()
+		router.AddJob(3, &BaseJob{}).AddJob(1, &BaseJob{})
+		router.AddJobRange(10, 19, &BaseJob{})
+
+		tags := router.Tags()
+		assert.Equal(t, []uint16{1, 3}, tags[:2])
+		assert.Contains(t, tags, uint16(HeartBeatTag))
+		routes := router.Routes()
+		if assert.Greater(t, len(routes), 3) {
+			assert.Equal(t, TagRange{From: 1, To: 1}, routes[0].Tags)
+			assert.Equal(t, TagRange{From: 10, To: 19}, routes[2].Tags)
+		}
+	})
+
+	t.Run("Reserved", func(t *testing.T) {
+		router := NewJobRouter()
+		assert.ErrorIs(t, router.Register(HeartBeatTag, &BaseJob{}), ErrReservedTag)
+		assert.ErrorIs(t, router.RegisterRange(90, 110, &BaseJob{}), ErrReservedTag)
+		assert.ErrorIs(t, router.Group().Register(SystemTagMax, &BaseJob{}), ErrReservedTag)
+		assert.NoError(t, router.Register(SystemTagMax+1, &BaseJob{}))
+		assert.Error(t, router.RegisterSystem(1, &BaseJob{}))
+		assert.NotNil(t, router.GetJob(PingTag))
+	})
+}
@@ -0,0 +1,177 @@
+package job
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/utils"
+
+	"github.com/google/uuid"
+)
+
+// 以下是框架内置的系统路由，均注册在系统tag区间内
+
+// registerSystemJobs 注册内置的系统路由
+func (r *JobRouter) registerSystemJobs() {
+	jobs := map[uint16]IJob{
+		HeartBeatTag:    &HeartBeatJob{},
+		PingTag:         &PingJob{},
+		PongTag:         &PongJob{},
+		TimeTag:         &TimeJob{},
+		CapabilitiesTag: &CapabilitiesJob{router: r},
+		CloseTag:        &CloseJob{},
+	}
+	for tag, job := range jobs {
+		if err := r.RegisterSystem(tag, job); err != nil {
+			logger.Errorf("register system job failed: %v", err)
+		}
+	}
+}
+
+// reply 以请求的序列号回复消息
+func reply(req common.IRequest, tag uint16, body []byte) error {
+	return req.Session().SendMsg(message.NewSeqedTLVMsg(req.Msg().Serial(), tag, body))
+}
+
+// EncodeTimestamp 将时间编码为8字节的unix纳秒时间戳
+func EncodeTimestamp(t time.Time) []byte {
+	return binary.NativeEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+// DecodeTimestamp 从data的前8字节解析出 EncodeTimestamp 编码的时间
+func DecodeTimestamp(data []byte) (time.Time, error) {
+	if len(data) < 8 {
+		return time.Time{}, errors.New("data length is less than timestamp size")
+	}
+	return time.Unix(0, int64(binary.NativeEndian.Uint64(data[:8]))), nil
+}
+
+// HeartBeatAck 根据收到的心跳生成回应的Body：原样带回发起方的时间戳并追加本端的时间戳。
+// 收到的是空心跳或者本身就是回应时不需要回应，返回nil
+func HeartBeatAck(body []byte, now time.Time) []byte {
+	if len(body) != 8 {
+		return nil
+	}
+	return append(append([]byte(nil), body...), EncodeTimestamp(now)...)
+}
+
+// MeasureHeartBeat 根据收到的心跳回应计算RTT和对端的时钟偏差，body不是回应时ok为false
+func MeasureHeartBeat(body []byte, now time.Time) (rtt, skew time.Duration, ok bool) {
+	if len(body) != 16 {
+		return 0, 0, false
+	}
+	sent, _ := DecodeTimestamp(body[:8])
+	peer, _ := DecodeTimestamp(body[8:])
+	rtt = now.Sub(sent)
+	// 假设去程和回程的延迟相同，对端回应时本端的时间约为 sent + rtt/2
+	skew = peer.Sub(sent.Add(rtt / 2))
+	return rtt, skew, true
+}
+
+// EncodeClose 编码关闭消息的Body：4字节的关闭码 + 说明
+func EncodeClose(reason common.CloseReason, msg string) []byte {
+	return append(binary.NativeEndian.AppendUint32(nil, uint32(reason)), msg...)
+}
+
+// DecodeClose 解析 EncodeClose 编码的Body
+func DecodeClose(data []byte) (common.CloseReason, string, error) {
+	if len(data) < 4 {
+		return common.CloseNone, "", errors.New("data length is less than close code size")
+	}
+	return common.CloseReason(binary.NativeEndian.Uint32(data[:4])), string(data[4:]), nil
+}
+
+// EncodeHandshake 编码握手消息的Body：16字节的会话ID + 恢复令牌
+func EncodeHandshake(id uuid.UUID, token string) []byte {
+	return append(id[:], token...)
+}
+
+// DecodeHandshake 解析 EncodeHandshake 编码的Body
+func DecodeHandshake(data []byte) (id uuid.UUID, token string, err error) {
+	if len(data) < len(id) {
+		return id, "", errors.New("data length is less than handshake size")
+	}
+	copy(id[:], data[:len(id)])
+	return id, string(data[len(id):]), nil
+}
+
+// PingJob 响应对端的Ping：原样带回对端的时间戳，并追加本端的时间戳
+type PingJob struct {
+	BaseJob
+}
+
+func (j *PingJob) Handle(req common.IRequest) error {
+	body := append(append([]byte(nil), req.Msg().Body()...), EncodeTimestamp(time.Now())...)
+	return reply(req, PongTag, body)
+}
+
+// Ping 由服务端向session发起Ping，不等待Pong。框架的会话收到Pong后记录RTT（见 HeartBeatStats），需要等待结果时使用会话的Ping方法
+func Ping(session common.ISession) error {
+	return session.SendMsg(message.NewSeqedTLVMsg(0, PingTag, EncodeTimestamp(time.Now())))
+}
+
+// PongJob 处理服务端发起的Ping的回复，计算RTT。
+// 框架的会话在读协程中直接处理Pong并记录RTT，不经过路由，这里用于自定义的 common.ISession 实现
+type PongJob struct {
+	BaseJob
+}
+
+func (j *PongJob) Handle(req common.IRequest) error {
+	sent, err := DecodeTimestamp(req.Msg().Body())
+	if err != nil {
+		return fmt.Errorf("parse pong error: %w", err)
+	}
+	logger.Debugf("Session %s RTT: %v", req.Session().ID(), time.Since(sent))
+	return nil
+}
+
+// TimeJob 回复服务端当前时间
+type TimeJob struct {
+	BaseJob
+}
+
+func (j *TimeJob) Handle(req common.IRequest) error {
+	return reply(req, TimeTag, EncodeTimestamp(time.Now()))
+}
+
+// Capabilities 服务端的协议能力
+type Capabilities struct {
+	Version string `json:"version"`
+	// 0表示不限制
+	MaxPacketSize uint32   `json:"max_packet_size"`
+	HeartBeatTick uint     `json:"heartbeat_tick"`
+	Tags          []uint16 `json:"tags"`
+}
+
+// CapabilitiesJob 回复服务端的协议能力，包括已注册的全部tag和当前会话实际接受的最大消息体长度
+type CapabilitiesJob struct {
+	BaseJob
+	router *JobRouter
+}
+
+func (j *CapabilitiesJob) Handle(req common.IRequest) error {
+	data, err := json.Marshal(Capabilities{
+		Version:       utils.GetVersion(),
+		MaxPacketSize: req.Session().MaxPacketSize(),
+		HeartBeatTick: utils.Conf.Server.HeartBeatTick,
+		Tags:          j.router.Tags(),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal capabilities error: %w", err)
+	}
+	return reply(req, CapabilitiesTag, data)
+}
+
+// CloseJob 确认客户端的优雅关闭请求，之后由客户端主动断开连接
+type CloseJob struct {
+	BaseJob
+}
+
+func (j *CloseJob) Handle(req common.IRequest) error {
+	return reply(req, CloseTag, nil)
+}
@@ -0,0 +1,95 @@
+package job
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Meha555/pulse/core/codec"
+	"github.com/Meha555/pulse/server/common"
+)
+
+// TypedFunc 强类型的业务处理函数，ctx在会话关闭时被取消
+// 返回的Resp会被编码后以请求的tag和序列号回复给请求方；Resp为nil时不回复；返回错误时以ErrorTag回复错误信息
+type TypedFunc[Req, Resp any] func(ctx context.Context, req *Req) (*Resp, error)
+
+type typedOption func(*typedConfig)
+
+type typedConfig struct {
+	codec codec.Codec
+}
+
+// WithCodec 指定请求和响应的编解码器，默认为 codec.Default
+func WithCodec(c codec.Codec) typedOption {
+	return func(cfg *typedConfig) {
+		cfg.codec = c
+	}
+}
+
+type requestKey struct{}
+
+// RequestFromContext 从 TypedFunc 收到的ctx中取出原始请求，以便访问会话等信息
+func RequestFromContext(ctx context.Context) (common.IRequest, bool) {
+	req, ok := ctx.Value(requestKey{}).(common.IRequest)
+	return req, ok
+}
+
+// sessionContext 返回携带请求的ctx，会话关闭（包括服务端关闭时清理所有会话）时被取消
+func sessionContext(req common.IRequest) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), requestKey{}, req))
+	if exit := req.Session().ExitChan(); exit != nil {
+		go func() {
+			select {
+			case <-exit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+// Typed 将强类型的处理函数适配为 IJob，省去在 PreHandle/Handle/PostHandle 之间用 Set/Get 传递参数
+func Typed[Req, Resp any](fn TypedFunc[Req, Resp], opts ...typedOption) IJob {
+	cfg := typedConfig{codec: codec.Default}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &typedJob[Req, Resp]{fn: fn, codec: cfg.codec}
+}
+
+type typedJob[Req, Resp any] struct {
+	BaseJob
+	fn    TypedFunc[Req, Resp]
+	codec codec.Codec
+}
+
+func (j *typedJob[Req, Resp]) Handle(req common.IRequest) error {
+	in := new(Req)
+	if body := req.Msg().Body(); len(body) > 0 {
+		if err := j.codec.Unmarshal(body, in); err != nil {
+			return j.fail(req, fmt.Errorf("decode request with %s error: %w", j.codec.Name(), err))
+		}
+	}
+	ctx, cancel := sessionContext(req)
+	defer cancel()
+	out, err := j.fn(ctx, in)
+	if err != nil {
+		return j.fail(req, err)
+	}
+	if out == nil {
+		return nil
+	}
+	data, err := j.codec.Marshal(out)
+	if err != nil {
+		return j.fail(req, fmt.Errorf("encode response with %s error: %w", j.codec.Name(), err))
+	}
+	return reply(req, req.Msg().Tag(), data)
+}
+
+// fail 将错误回复给请求方，并原样返回给路由
+func (j *typedJob[Req, Resp]) fail(req common.IRequest, err error) error {
+	if replyErr := ReplyError(req, err); replyErr != nil {
+		logger.Errorf("reply error of tag[%d] failed: %v", req.Msg().Tag(), replyErr)
+	}
+	return err
+}
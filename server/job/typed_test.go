@@ -0,0 +1,98 @@
+package job_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	. "github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/server/session"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSession 只记录发出的消息
+type fakeSession struct {
+	common.ISession
+	sent   []message.ISeqedTLVMsg
+	exitCh chan struct{}
+}
+
+func (s *fakeSession) ExitChan() <-chan struct{} {
+	return s.exitCh
+}
+
+func (s *fakeSession) SendMsg(msg message.IPacket) error {
+	s.sent = append(s.sent, msg.(message.ISeqedTLVMsg))
+	return nil
+}
+
+type sumReq struct {
+	A, B int
+}
+
+type sumResp struct {
+	Sum int
+}
+
+func TestTyped(t *testing.T) {
+	router := NewJobRouter()
+	router.AddJob(1, Typed(func(ctx context.Context, req *sumReq) (*sumResp, error) {
+		if _, ok := RequestFromContext(ctx); !ok {
+			return nil, errors.New("no request in context")
+		}
+		if req.B < 0 {
+			return nil, errors.New("negative")
+		}
+		return &sumResp{Sum: req.A + req.B}, nil
+	}))
+	router.AddJob(2, Typed(func(ctx context.Context, req *sumReq) (*sumResp, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return nil, errors.New("context not canceled")
+		}
+	}))
+
+	t.Run("Reply", func(t *testing.T) {
+		s := &fakeSession{}
+		err := router.ExecJob(1, session.NewRequest(s, message.NewSeqedTLVMsg(7, 1, []byte(`{"A":1,"B":2}`))))
+		assert.NoError(t, err)
+		if assert.Len(t, s.sent, 1) {
+			assert.Equal(t, uint32(7), s.sent[0].Serial())
+			assert.Equal(t, uint16(1), s.sent[0].Tag())
+			assert.JSONEq(t, `{"Sum":3}`, string(s.sent[0].Body()))
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		s := &fakeSession{}
+		err := router.ExecJob(1, session.NewRequest(s, message.NewSeqedTLVMsg(8, 1, []byte(`{"A":1,"B":-1}`))))
+		assert.Error(t, err)
+		if assert.Len(t, s.sent, 1) {
+			assert.Equal(t, uint32(8), s.sent[0].Serial())
+			assert.Equal(t, uint16(ErrorTag), s.sent[0].Tag())
+			assert.Equal(t, "negative", string(s.sent[0].Body()))
+		}
+	})
+
+	t.Run("Session closed", func(t *testing.T) {
+		s := &fakeSession{exitCh: make(chan struct{})}
+		time.AfterFunc(10*time.Millisecond, func() { close(s.exitCh) })
+		err := router.ExecJob(2, session.NewRequest(s, message.NewSeqedTLVMsg(10, 2, nil)))
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Decode error", func(t *testing.T) {
+		s := &fakeSession{}
+		err := router.ExecJob(1, session.NewRequest(s, message.NewSeqedTLVMsg(9, 1, []byte(`not json`))))
+		assert.Error(t, err)
+		if assert.Len(t, s.sent, 1) {
+			assert.Equal(t, uint16(ErrorTag), s.sent[0].Tag())
+		}
+	})
+}
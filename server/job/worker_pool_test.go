@@ -52,11 +52,16 @@ func (m *MockJobRouter) ExecJob(tag uint16, request common.IRequest) error {
 	return args.Error(0)
 }
 
-func (m *MockJobRouter) AddJob(tag uint16, job IJob) IJobRouter {
+func (m *MockJobRouter) AddJob(tag uint16, job IJob, mws ...Middleware) IJobRouter {
 	m.Called(tag, job)
 	return m
 }
 
+func (m *MockJobRouter) Use(mws ...Middleware) IJobRouter {
+	m.Called()
+	return m
+}
+
 func (m *MockJobRouter) GetJob(tag uint16) IJob {
 	args := m.Called(tag)
 	return args.Get(0).(IJob)
@@ -80,6 +85,14 @@ func (m *MockIRequest) Session() common.ISession {
 	return args.Get(0).(common.ISession)
 }
 
+func (m *MockIRequest) Principal() *common.Principal {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).(*common.Principal)
+}
+
 func (m *MockIRequest) Get(key string) (value interface{}, exists bool) {
 	args := m.Called(key)
 	return args.Get(0), args.Bool(1)
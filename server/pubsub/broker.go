@@ -0,0 +1,242 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/core/topic"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+
+	"github.com/Meha555/go-tinylog"
+	"github.com/google/uuid"
+)
+
+var logger *tinylog.Logger
+
+func init() {
+	var err error
+	logger, err = tinylog.NewStdLogger(tinylog.LevelInfo, "pubsub", "[%t] [%c %l] [%f:%C:%L:%g] %m", false, tinylog.Lcolored)
+	if err != nil {
+		panic(err)
+	}
+}
+
+const (
+	// 每个订阅者待投递消息队列的默认容量
+	kDefaultQueueSize = 64
+)
+
+var ErrNotSubscribed = errors.New("not subscribed")
+
+// OverflowPolicy 订阅者的待投递队列已满时的处理策略
+type OverflowPolicy int
+
+const (
+	// 丢弃新消息
+	DropNewest OverflowPolicy = iota
+	// 断开订阅者的连接
+	Disconnect
+)
+
+// subscriber 一个订阅了主题的会话，拥有独立的待投递队列，慢订阅者不会拖慢发布者
+type subscriber struct {
+	session  common.ISession
+	patterns map[string]struct{}
+	queue    chan message.IPacket
+	// 订阅者被移除时关闭
+	done chan struct{}
+}
+
+// run 将队列中的消息投递给会话
+func (s *subscriber) run() {
+	for {
+		select {
+		case msg := <-s.queue:
+			if err := s.session.SendMsg(msg); err != nil {
+				logger.Warnf("deliver to session %s error: %v", s.session.ID(), err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Broker 管理主题订阅并向订阅者投递消息
+type Broker struct {
+	subs      map[uuid.UUID]*subscriber
+	queueSize int
+	policy    OverflowPolicy
+	mtx       sync.RWMutex
+}
+
+type Option func(*Broker)
+
+// WithQueueSize 设置每个订阅者待投递队列的容量
+func WithQueueSize(size int) Option {
+	return func(b *Broker) {
+		b.queueSize = size
+	}
+}
+
+// WithOverflowPolicy 设置待投递队列已满时的处理策略，默认为 DropNewest
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(b *Broker) {
+		b.policy = policy
+	}
+}
+
+func NewBroker(opts ...Option) *Broker {
+	b := &Broker{
+		subs:      make(map[uuid.UUID]*subscriber),
+		queueSize: kDefaultQueueSize,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Register 在路由器上注册订阅、取消订阅和发布的系统路由
+func (b *Broker) Register(router *job.JobRouter) error {
+	for tag, j := range map[uint16]job.IJob{
+		job.SubscribeTag:   &SubscribeJob{broker: b},
+		job.UnsubscribeTag: &UnsubscribeJob{broker: b},
+		job.PublishTag:     &PublishJob{broker: b},
+	} {
+		if err := router.RegisterSystem(tag, j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe 让会话订阅主题，pattern可以包含通配符。会话关闭后自动取消所有订阅
+func (b *Broker) Subscribe(session common.ISession, pattern string) error {
+	if err := topic.ValidatePattern(pattern); err != nil {
+		return err
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	sub, ok := b.subs[session.ID()]
+	if !ok {
+		sub = &subscriber{
+			session:  session,
+			patterns: make(map[string]struct{}),
+			queue:    make(chan message.IPacket, b.queueSize),
+			done:     make(chan struct{}),
+		}
+		b.subs[session.ID()] = sub
+		go sub.run()
+		go b.watch(sub)
+	}
+	sub.patterns[pattern] = struct{}{}
+	logger.Debugf("session %s subscribe %s", session.ID(), pattern)
+	return nil
+}
+
+// watch 会话关闭后移除订阅者
+func (b *Broker) watch(sub *subscriber) {
+	select {
+	case <-sub.session.ExitChan():
+		b.remove(sub)
+	case <-sub.done:
+	}
+}
+
+// Unsubscribe 取消会话对pattern的订阅，pattern需要与订阅时一致
+func (b *Broker) Unsubscribe(sessionID uuid.UUID, pattern string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	sub, ok := b.subs[sessionID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotSubscribed, pattern)
+	}
+	if _, ok := sub.patterns[pattern]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotSubscribed, pattern)
+	}
+	delete(sub.patterns, pattern)
+	if len(sub.patterns) == 0 {
+		delete(b.subs, sessionID)
+		close(sub.done)
+	}
+	return nil
+}
+
+// remove 移除订阅者的所有订阅
+func (b *Broker) remove(sub *subscriber) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.subs[sub.session.ID()] != sub {
+		return
+	}
+	delete(b.subs, sub.session.ID())
+	close(sub.done)
+}
+
+// Publish 向订阅了匹配主题的所有会话投递消息，返回成功放入待投递队列的订阅者个数。
+// 投递是异步的，不会因为某个订阅者慢而阻塞
+func (b *Broker) Publish(name string, payload []byte) (int, error) {
+	if err := topic.ValidateTopic(name); err != nil {
+		return 0, err
+	}
+	msg := message.NewSeqedTLVMsg(0, job.DeliverTag, topic.Encode(name, payload))
+
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	delivered := 0
+	for _, sub := range b.subs {
+		if !sub.matches(name) {
+			continue
+		}
+		select {
+		case sub.queue <- msg:
+			delivered++
+		default:
+			b.overflow(sub, name)
+		}
+	}
+	return delivered, nil
+}
+
+// matches 判断订阅者是否订阅了主题，调用方需持有b.mtx
+func (s *subscriber) matches(name string) bool {
+	for pattern := range s.patterns {
+		if topic.Match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// overflow 处理订阅者的待投递队列已满
+func (b *Broker) overflow(sub *subscriber, name string) {
+	switch b.policy {
+	case Disconnect:
+		logger.Warnf("session %s is too slow to consume topic %s, disconnect it", sub.session.ID(), name)
+		go func() {
+			if kicker, ok := sub.session.(interface{ Kick(reason string) error }); ok {
+				kicker.Kick("subscriber queue overflow")
+				return
+			}
+			sub.session.Close(common.CloseKicked)
+		}()
+	default:
+		logger.Warnf("session %s is too slow to consume topic %s, drop msg", sub.session.ID(), name)
+	}
+}
+
+// Subscribers 返回订阅了匹配主题的会话个数
+func (b *Broker) Subscribers(name string) int {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	n := 0
+	for _, sub := range b.subs {
+		if sub.matches(name) {
+			n++
+		}
+	}
+	return n
+}
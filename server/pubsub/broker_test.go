@@ -0,0 +1,147 @@
+package pubsub_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/client"
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/server/pubsub"
+	"github.com/Meha555/pulse/server/session"
+	"github.com/Meha555/pulse/utils"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newServer 启动开启了发布订阅的测试服务端
+func newServer(t *testing.T, broker *pubsub.Broker) uint16 {
+	router := job.NewJobRouter()
+	require.NoError(t, broker.Register(router))
+	pool := job.NewWorkerPool(2, utils.NewBlockingQueue[common.IRequest](2), router)
+	pool.Start()
+	mgr := session.NewSessionMgr()
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+		mgr.Clear()
+		pool.Stop()
+	})
+	go func() {
+		for {
+			conn, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			s := session.NewSession(conn, pool)
+			mgr.Add(s)
+			go s.Open()
+		}
+	}()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+type delivery struct {
+	topic   string
+	payload string
+}
+
+func subscribe(t *testing.T, cli *client.Client, pattern string) chan delivery {
+	ch := make(chan delivery, 8)
+	require.NoError(t, cli.Subscribe(context.Background(), pattern, func(topic string, payload []byte) {
+		ch <- delivery{topic, string(payload)}
+	}))
+	return ch
+}
+
+func expect(t *testing.T, ch chan delivery, want delivery) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		assert.Equal(t, want, got)
+	case <-time.After(time.Second):
+		t.Fatalf("wait for %v timeout", want)
+	}
+}
+
+func TestBroker(t *testing.T) {
+	broker := pubsub.NewBroker()
+	port := newServer(t, broker)
+	alice, err := client.Dial("127.0.0.1", port)
+	require.NoError(t, err)
+	defer alice.Close()
+	bob, err := client.Dial("127.0.0.1", port)
+	require.NoError(t, err)
+	defer bob.Close()
+
+	all := subscribe(t, alice, "chat.*")
+	room1 := subscribe(t, bob, "chat.room1")
+
+	// 服务端发布
+	n, err := broker.Publish("chat.room1", []byte("hi"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	expect(t, all, delivery{"chat.room1", "hi"})
+	expect(t, room1, delivery{"chat.room1", "hi"})
+
+	// 客户端发布
+	require.NoError(t, bob.Publish(context.Background(), "chat.room2", []byte("hello")))
+	expect(t, all, delivery{"chat.room2", "hello"})
+	assert.Empty(t, room1)
+
+	require.NoError(t, bob.Unsubscribe(context.Background(), "chat.room1"))
+	assert.Equal(t, 1, broker.Subscribers("chat.room1"))
+	assert.Error(t, bob.Unsubscribe(context.Background(), "chat.room1"))
+	assert.Error(t, alice.Subscribe(context.Background(), "chat.#.bad", func(string, []byte) {}))
+
+	// 连接关闭后自动取消订阅
+	alice.Close()
+	assert.Eventually(t, func() bool { return broker.Subscribers("chat.room1") == 0 }, time.Second, 10*time.Millisecond)
+}
+
+// slowSession 阻塞在发送上的会话
+type slowSession struct {
+	common.ISession
+	id     uuid.UUID
+	exitCh chan struct{}
+	closed chan struct{}
+}
+
+func (s *slowSession) ID() uuid.UUID                     { return s.id }
+func (s *slowSession) ExitChan() <-chan struct{}         { return s.exitCh }
+func (s *slowSession) SendMsg(msg message.IPacket) error { <-s.exitCh; return nil }
+func (s *slowSession) Close(common.CloseReason)          { close(s.closed) }
+
+func TestBroker_Overflow(t *testing.T) {
+	for name, policy := range map[string]pubsub.OverflowPolicy{"Drop": pubsub.DropNewest, "Disconnect": pubsub.Disconnect} {
+		t.Run(name, func(t *testing.T) {
+			broker := pubsub.NewBroker(pubsub.WithQueueSize(1), pubsub.WithOverflowPolicy(policy))
+			s := &slowSession{id: uuid.New(), exitCh: make(chan struct{}), closed: make(chan struct{})}
+			defer close(s.exitCh)
+			require.NoError(t, broker.Subscribe(s, "a"))
+
+			// 第一条消息被投递协程取走并阻塞，第二条进入队列，第三条溢出
+			delivered := 0
+			for range 3 {
+				n, err := broker.Publish("a", nil)
+				require.NoError(t, err)
+				delivered += n
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.Equal(t, 2, delivered)
+			if policy == pubsub.Disconnect {
+				select {
+				case <-s.closed:
+				case <-time.After(time.Second):
+					t.Fatal("slow subscriber not disconnected")
+				}
+			}
+		})
+	}
+}
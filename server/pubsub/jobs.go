@@ -0,0 +1,58 @@
+package pubsub
+
+import (
+	"fmt"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/core/topic"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+)
+
+// ack 以请求的序列号和tag回复空消息，表示请求成功
+func ack(req common.IRequest) error {
+	return req.Session().SendMsg(message.NewSeqedTLVMsg(req.Msg().Serial(), req.Msg().Tag(), nil))
+}
+
+// SubscribeJob 处理客户端的订阅请求
+type SubscribeJob struct {
+	job.BaseJob
+	broker *Broker
+}
+
+func (j *SubscribeJob) Handle(req common.IRequest) error {
+	if err := j.broker.Subscribe(req.Session(), string(req.Msg().Body())); err != nil {
+		return job.ReplyError(req, err)
+	}
+	return ack(req)
+}
+
+// UnsubscribeJob 处理客户端的取消订阅请求
+type UnsubscribeJob struct {
+	job.BaseJob
+	broker *Broker
+}
+
+func (j *UnsubscribeJob) Handle(req common.IRequest) error {
+	if err := j.broker.Unsubscribe(req.Session().ID(), string(req.Msg().Body())); err != nil {
+		return job.ReplyError(req, err)
+	}
+	return ack(req)
+}
+
+// PublishJob 处理客户端发布的消息
+type PublishJob struct {
+	job.BaseJob
+	broker *Broker
+}
+
+func (j *PublishJob) Handle(req common.IRequest) error {
+	name, payload, err := topic.Decode(req.Msg().Body())
+	if err == nil {
+		_, err = j.broker.Publish(name, payload)
+	}
+	if err != nil {
+		return job.ReplyError(req, fmt.Errorf("publish error: %w", err))
+	}
+	return ack(req)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
 
 	"net"
@@ -8,8 +9,10 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/Meha555/pulse/server/auth"
 	"github.com/Meha555/pulse/server/common"
 	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/server/pubsub"
 	"github.com/Meha555/pulse/server/session"
 	"github.com/Meha555/pulse/utils"
 
@@ -34,12 +37,23 @@ type Server struct {
 
 	banner IBanner
 
+	// 不为nil时在TCP之上使用TLS
+	tlsConfig *tls.Config
+	// 不为nil时，连接必须通过认证才能发起请求
+	authenticator auth.Authenticator
+	// 作用于所有会话的钩子
+	hooks session.Hooks
+
 	// 连接管理器
 	sessionMgr common.ISessionMgr
+	// 会话分组管理器
+	groupMgr *session.GroupMgr
+	// 发布订阅，调用 EnablePubSub 后才可用
+	broker *pubsub.Broker
 	// 映射请求到具体的API回调
-	jobRouter job.IJobRouter
-	// 工作协程池
-	workerPool *job.WorkerPool
+	jobRouter *job.JobRouter
+	// 将请求交给业务协程处理，默认为完全并行的 job.WorkerPool
+	dispatcher job.Dispatcher
 }
 
 func NewServer() *Server {
@@ -52,16 +66,83 @@ func NewServer() *Server {
 		Ip:         utils.Conf.Server.Host,
 		Port:       utils.Conf.Server.Port,
 		sessionMgr: session.NewSessionMgr(),
+		groupMgr:   session.NewGroupMgr(),
 		jobRouter:  router,
-		workerPool: job.NewWorkerPool(mq.Cap(), mq, router),
+		dispatcher: job.NewWorkerPool(mq.Cap(), mq, router),
 	}
 }
 
-func (s *Server) Route(tag uint16, job job.IJob) *Server {
-	s.jobRouter.AddJob(tag, job)
+// Route 注册路由，mws为仅作用于该路由的中间件
+func (s *Server) Route(tag uint16, job job.IJob, mws ...job.Middleware) *Server {
+	s.jobRouter.AddJob(tag, job, mws...)
+	return s
+}
+
+// RouteRange 为闭区间[from, to]内的所有tag注册同一个job
+func (s *Server) RouteRange(from, to uint16, job job.IJob, mws ...job.Middleware) *Server {
+	s.jobRouter.AddJobRange(from, to, job, mws...)
+	return s
+}
+
+// Group 创建共享中间件的路由组
+func (s *Server) Group(mws ...job.Middleware) *job.RouteGroup {
+	return s.jobRouter.Group(mws...)
+}
+
+// Use 注册作用于所有路由的全局中间件
+func (s *Server) Use(mws ...job.Middleware) *Server {
+	s.jobRouter.Use(mws...)
 	return s
 }
 
+// NotFound 设置找不到路由时的处理函数
+func (s *Server) NotFound(handler job.HandlerFunc) *Server {
+	s.jobRouter.SetNotFound(handler)
+	return s
+}
+
+// Sessions 返回连接管理器，用于向客户端推送、广播消息或踢出连接
+func (s *Server) Sessions() common.ISessionMgr {
+	return s.sessionMgr
+}
+
+// Groups 返回会话分组管理器
+func (s *Server) Groups() *session.GroupMgr {
+	return s.groupMgr
+}
+
+// EnablePubSub 开启发布订阅，注册订阅、取消订阅和发布的系统路由。
+// 服务端代码通过返回的 pubsub.Broker 发布消息，重复调用返回同一个Broker
+func (s *Server) EnablePubSub(opts ...pubsub.Option) *pubsub.Broker {
+	if s.broker != nil {
+		return s.broker
+	}
+	broker := pubsub.NewBroker(opts...)
+	if err := broker.Register(s.jobRouter); err != nil {
+		logger.Errorf("enable pubsub failed: %v", err)
+		return nil
+	}
+	s.broker = broker
+	return broker
+}
+
+// EnableOrderedDispatch 按key有序处理请求：key相同的请求按到达顺序依次处理，不同key的请求并行处理。
+// key为nil时按会话分片，即同一个连接的请求有序。默认所有请求完全并行处理。需要在Listen之前调用
+func (s *Server) EnableOrderedDispatch(key job.ShardKey) {
+	workers := int(utils.Conf.Server.MaxWorkerPoolSize)
+	s.dispatcher = job.NewShardedPool(workers, int(utils.Conf.Server.MaxMsgQueueSize), s.jobRouter, key)
+}
+
+// Broker 返回发布订阅的Broker，未开启时为nil
+func (s *Server) Broker() *pubsub.Broker {
+	return s.broker
+}
+
+// Routes 返回所有已注册的路由
+func (s *Server) Routes() []job.RouteInfo {
+	return s.jobRouter.Routes()
+}
+
 func (s *Server) Listen() {
 	logger.Infof("Server Start with config: %s\n", utils.Conf)
 
@@ -79,12 +160,10 @@ func (s *Server) Listen() {
 		logger.Errorf("ListenTCP error: %v", err)
 		return
 	}
-	logger.Infof("%s Listening on %s:%d ...", s.Name, s.Ip, s.Port)
+	logger.Infof("%s Listening on %s:%d (tls: %v) ...", s.Name, s.Ip, s.Port, s.tlsConfig != nil)
 
-	// 注册心跳路由
-	s.jobRouter.AddJob(job.HeartBeatTag, &job.HeartBeatJob{})
 	// 启动协程池
-	s.workerPool.Start()
+	s.dispatcher.Start()
 
 	// 启用单独的协程来处理客户端连接
 	// 这是go语言的风格，能用异步一般用异步。这样主协程接下来还可以做其他工作，比如后面的Serve()方法
@@ -102,7 +181,16 @@ func (s *Server) Listen() {
 			}
 			logger.Debugf("New connection from %s", peer.RemoteAddr())
 
-			clientSession := session.NewSession(peer, s.workerPool)
+			var conn net.Conn = peer
+			if s.tlsConfig != nil {
+				// TLS握手在会话的协程中第一次读写时进行，不阻塞accept
+				conn = tls.Server(peer, s.tlsConfig)
+			}
+			clientSession := session.NewSession(conn, s.dispatcher,
+				session.WithAuthenticator(s.authenticator),
+				session.WithHooks(s.hooks),
+				// 在认证之前就限制消息长度，避免对端用超大的长度字段让服务端分配内存
+				session.WithMaxPacketSize(utils.Conf.Server.MaxPacketSize))
 			s.sessionMgr.Add(clientSession)
 			// 启动子协程处理业务
 			go clientSession.Open()
@@ -126,7 +214,7 @@ func (s *Server) Shutdown() {
 	// 将其它需要清理的连接信息或其他信息一并停止或清理
 
 	s.sessionMgr.Clear()
-	s.workerPool.Stop()
+	s.dispatcher.Stop()
 }
 
 func (s *Server) ListenAndServe() {
@@ -141,5 +229,20 @@ func (s *Server) SetBanner(banner IBanner) {
 	s.banner = banner
 }
 
+// SetTLSConfig 开启TLS，使用客户端证书认证时需要设置ClientAuth和ClientCAs。需要在Listen之前调用
+func (s *Server) SetTLSConfig(cfg *tls.Config) {
+	s.tlsConfig = cfg
+}
+
+// SetAuthenticator 设置认证器，连接在认证通过之前不会路由任何请求。需要在Listen之前调用
+func (s *Server) SetAuthenticator(authenticator auth.Authenticator) {
+	s.authenticator = authenticator
+}
+
+// SetHooks 设置作用于所有会话的生命周期钩子，见 session.Hooks。需要在Listen之前调用
+func (s *Server) SetHooks(hooks session.Hooks) {
+	s.hooks = hooks
+}
+
 // 确保 Server 实现了 IServer 的所有方法（让编译器帮我们检查）
 var _ IServer = (*Server)(nil)
@@ -0,0 +1,204 @@
+package session
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/Meha555/pulse/server/common"
+
+	"github.com/google/uuid"
+)
+
+// attributes 会话的属性存储
+type attributes struct {
+	m      map[string]any
+	closed bool
+	// 属性变化后的通知，由 SessionMgr 用于维护属性索引
+	onChange func(key string)
+	mtx      sync.RWMutex
+}
+
+func newAttributes() *attributes {
+	return &attributes{m: make(map[string]any)}
+}
+
+func (a *attributes) Set(key string, value any) {
+	a.mtx.Lock()
+	if a.closed {
+		a.mtx.Unlock()
+		return
+	}
+	a.m[key] = value
+	onChange := a.onChange
+	a.mtx.Unlock()
+	// 释放锁后再通知，避免与 SessionMgr 的锁形成环
+	if onChange != nil {
+		onChange(key)
+	}
+}
+
+func (a *attributes) Get(key string) (any, bool) {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	value, ok := a.m[key]
+	return value, ok
+}
+
+func (a *attributes) Delete(key string) {
+	a.mtx.Lock()
+	_, ok := a.m[key]
+	delete(a.m, key)
+	onChange := a.onChange
+	a.mtx.Unlock()
+	if ok && onChange != nil {
+		onChange(key)
+	}
+}
+
+// Range 在快照上遍历，fn中可以修改属性
+func (a *attributes) Range(fn func(key string, value any) bool) {
+	a.mtx.RLock()
+	values := make(map[string]any, len(a.m))
+	for key, value := range a.m {
+		values[key] = value
+	}
+	a.mtx.RUnlock()
+	keys := sortedKeys(values)
+	for _, key := range keys {
+		if !fn(key, values[key]) {
+			return
+		}
+	}
+}
+
+// setOnChange 设置属性变化的通知
+func (a *attributes) setOnChange(fn func(key string)) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.onChange = fn
+}
+
+// close 清空属性且不再接受新属性。不会通知 SessionMgr，索引由 SessionMgr.Del 清理
+func (a *attributes) close() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.closed = true
+	clear(a.m)
+}
+
+var _ common.IAttributes = (*attributes)(nil)
+
+// indexable 判断属性值能否作为索引的key
+func indexable(value any) bool {
+	return value != nil && reflect.TypeOf(value).Comparable()
+}
+
+// WithIndex 为属性key建立索引，使 SessionMgr.Lookup 不必遍历所有连接
+func WithIndex(keys ...string) SessionMgrOption {
+	return func(c *SessionMgr) {
+		for _, key := range keys {
+			c.indexes[key] = make(map[any]map[uuid.UUID]struct{})
+		}
+	}
+}
+
+// Index 为属性key建立索引，已有连接的属性也会被加入索引
+func (c *SessionMgr) Index(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.indexes[key]; ok {
+		return
+	}
+	c.indexes[key] = make(map[any]map[uuid.UUID]struct{})
+	for _, session := range c.sessionMap {
+		c.indexKey(session, key)
+	}
+}
+
+// Lookup 查找属性key的值等于value的连接，key没有建立索引时遍历所有连接
+func (c *SessionMgr) Lookup(key string, value any) []common.ISession {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	var sessions []common.ISession
+	if idx, ok := c.indexes[key]; ok {
+		if !indexable(value) {
+			return nil
+		}
+		for id := range idx[value] {
+			sessions = append(sessions, c.sessionMap[id])
+		}
+		return sessions
+	}
+	for _, session := range c.sessionMap {
+		if v, ok := session.Attrs().Get(key); ok && indexable(v) && v == value {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+// watchAttrs 在连接的属性变化时更新索引，调用方需持有c.mtx
+func (c *SessionMgr) watchAttrs(session common.ISession) {
+	for key := range c.indexes {
+		c.indexKey(session, key)
+	}
+	if s, ok := session.(*Session); ok {
+		s.attrs.setOnChange(func(key string) {
+			c.mtx.Lock()
+			defer c.mtx.Unlock()
+			if _, ok := c.indexes[key]; !ok {
+				return
+			}
+			if _, ok := c.sessionMap[s.ID()]; !ok {
+				return
+			}
+			c.unindexKey(s.ID(), key)
+			c.indexKey(s, key)
+		})
+	}
+}
+
+// indexKey 将连接的属性key加入索引，调用方需持有c.mtx
+func (c *SessionMgr) indexKey(session common.ISession, key string) {
+	value, ok := session.Attrs().Get(key)
+	if !ok {
+		return
+	}
+	if !indexable(value) {
+		logger.Warnf("Session %s attr %s of type %T can not be indexed", session.ID(), key, value)
+		return
+	}
+	idx := c.indexes[key]
+	if idx[value] == nil {
+		idx[value] = make(map[uuid.UUID]struct{})
+	}
+	idx[value][session.ID()] = struct{}{}
+	if c.indexed[session.ID()] == nil {
+		c.indexed[session.ID()] = make(map[string]any)
+	}
+	c.indexed[session.ID()][key] = value
+}
+
+// unindexKey 将连接的属性key移出索引，调用方需持有c.mtx
+func (c *SessionMgr) unindexKey(id uuid.UUID, key string) {
+	value, ok := c.indexed[id][key]
+	if !ok {
+		return
+	}
+	delete(c.indexed[id], key)
+	if len(c.indexed[id]) == 0 {
+		delete(c.indexed, id)
+	}
+	idx := c.indexes[key]
+	delete(idx[value], id)
+	if len(idx[value]) == 0 {
+		delete(idx, value)
+	}
+}
+
+// unindex 将连接的所有属性移出索引，调用方需持有c.mtx
+func (c *SessionMgr) unindex(id uuid.UUID) {
+	for key := range c.indexed[id] {
+		c.unindexKey(id, key)
+	}
+}
@@ -0,0 +1,101 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/server/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPipeSession 创建会话，返回会话和对端连接。mgr不为nil时将会话加入mgr并启动
+func newPipeSession(t *testing.T, mgr *SessionMgr) (*Session, net.Conn) {
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { peer.Close() })
+	s := NewSession(conn, nil)
+	if mgr != nil {
+		mgr.Add(s)
+		go s.Open()
+	}
+	return s, peer
+}
+
+func TestSession_Attrs(t *testing.T) {
+	var closedUID any
+	s, _ := newPipeSession(t, nil)
+	OnClose(func(session common.ISession, reason common.CloseReason) {
+		closedUID, _ = session.Attrs().Get("uid")
+	})(s)
+
+	attrs := s.Attrs()
+	attrs.Set("uid", 1)
+	attrs.Set("name", "alice")
+	v, ok := attrs.Get("uid")
+	require.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	var keys []string
+	attrs.Range(func(key string, _ any) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []string{"name", "uid"}, keys)
+
+	attrs.Delete("name")
+	_, ok = attrs.Get("name")
+	assert.False(t, ok)
+
+	// 关闭钩子中仍然可以读取属性，关闭后属性被清空
+	s.Close(common.CloseNormal)
+	assert.Equal(t, 1, closedUID)
+	_, ok = attrs.Get("uid")
+	assert.False(t, ok)
+	attrs.Set("uid", 2)
+	_, ok = attrs.Get("uid")
+	assert.False(t, ok)
+}
+
+func TestSessionMgr_Lookup(t *testing.T) {
+	mgr := NewSessionMgr(WithIndex("uid"))
+	defer mgr.Clear()
+	a, _ := newPipeSession(t, nil)
+	b, _ := newPipeSession(t, nil)
+	c, _ := newPipeSession(t, nil)
+	a.Attrs().Set("uid", "alice")
+	for _, s := range []*Session{a, b, c} {
+		mgr.Add(s)
+	}
+	b.Attrs().Set("uid", "bob")
+	c.Attrs().Set("uid", "bob")
+	c.Attrs().Set("room", 7)
+
+	ids := func(sessions []common.ISession) []string {
+		var ids []string
+		for _, s := range sessions {
+			ids = append(ids, s.ID().String())
+		}
+		return ids
+	}
+	assert.ElementsMatch(t, []string{a.ID().String()}, ids(mgr.Lookup("uid", "alice")))
+	assert.ElementsMatch(t, []string{b.ID().String(), c.ID().String()}, ids(mgr.Lookup("uid", "bob")))
+	// 没有建立索引的属性遍历查找
+	assert.ElementsMatch(t, []string{c.ID().String()}, ids(mgr.Lookup("room", 7)))
+
+	// 属性变化后更新索引
+	c.Attrs().Set("uid", "carol")
+	assert.ElementsMatch(t, []string{b.ID().String()}, ids(mgr.Lookup("uid", "bob")))
+	b.Attrs().Delete("uid")
+	assert.Empty(t, mgr.Lookup("uid", "bob"))
+
+	// 后建立的索引包含已有的属性
+	mgr.Index("room")
+	assert.ElementsMatch(t, []string{c.ID().String()}, ids(mgr.Lookup("room", 7)))
+
+	// 连接关闭后移出索引
+	a.Close(common.CloseNormal)
+	assert.Eventually(t, func() bool { return len(mgr.Lookup("uid", "alice")) == 0 }, time.Second, 10*time.Millisecond)
+	assert.Empty(t, mgr.indexed[a.ID()])
+}
@@ -0,0 +1,82 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/auth"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+)
+
+// WithAuthenticator 设置会话的认证器，会话在认证通过之前不会路由任何请求
+func WithAuthenticator(authenticator auth.Authenticator) hookOpt {
+	return func(c *Session) {
+		c.authenticator = authenticator
+	}
+}
+
+// authTransport 认证期间直接在连接上收发 job.AuthTag 消息
+type authTransport struct {
+	session *Session
+	// 最近一条认证消息的序列号，回复时带回
+	serial uint32
+}
+
+func (t *authTransport) Conn() net.Conn {
+	return t.session.Conn()
+}
+
+func (t *authTransport) Recv() ([]byte, error) {
+	msg := &message.SeqedTLVMsg{}
+	// 不经过收发钩子，认证消息中的凭据不能暴露给业务
+	if err := t.session.readMsg(msg); err != nil {
+		return nil, err
+	}
+	t.serial = msg.Serial()
+	if msg.Tag() != job.AuthTag {
+		return nil, fmt.Errorf("%w: expect auth msg, got tag[%d]", auth.ErrAuthFailed, msg.Tag())
+	}
+	return msg.Body(), nil
+}
+
+func (t *authTransport) Send(data []byte) error {
+	return t.session.writeDirect(message.NewSeqedTLVMsg(t.serial, job.AuthTag, data))
+}
+
+// authenticate 在读写协程启动之前认证对端，超时或失败时以 job.ErrorTag 告知对端，调用方负责记录返回的详细错误
+func (c *Session) authenticate() error {
+	conn := c.Conn()
+	timeout := time.Duration(utils.Conf.Server.AuthTimeout) * time.Second
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	t := &authTransport{session: c}
+	principal, err := c.authenticator.Authenticate(t)
+	conn.SetDeadline(time.Time{})
+	if err == nil && principal == nil {
+		err = auth.ErrAuthFailed
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = fmt.Errorf("%w after %v", auth.ErrAuthTimeout, timeout)
+	}
+	if err != nil {
+		// 只告知对端固定的错误，详细原因仅记录在服务端，避免泄露用户是否存在等信息
+		reply := auth.ErrAuthFailed
+		if errors.Is(err, auth.ErrAuthTimeout) {
+			reply = auth.ErrAuthTimeout
+		}
+		if replyErr := c.writeDirect(message.NewSeqedTLVMsg(t.serial, job.ErrorTag, []byte(reply.Error()))); replyErr != nil {
+			logger.Debugf("Session %s reply auth error: %v", c.ID(), replyErr)
+		}
+		return err
+	}
+	c.principal.Store(principal)
+	c.hookStub.onAuth(c, principal)
+	logger.Debugf("Session %s authenticated as %s", c.ID(), principal.UserID)
+	return c.writeDirect(message.NewSeqedTLVMsg(t.serial, job.AuthTag, []byte(principal.UserID)))
+}
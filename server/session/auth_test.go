@@ -0,0 +1,201 @@
+package session
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/client"
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/auth"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// whoamiJob 回复请求方的用户ID
+type whoamiJob struct {
+	job.BaseJob
+}
+
+func (j *whoamiJob) Handle(req common.IRequest) error {
+	return req.Session().SendMsg(message.NewSeqedTLVMsg(req.Msg().Serial(), req.Msg().Tag(), []byte(req.Principal().UserID)))
+}
+
+// newAuthServer 启动需要认证的测试服务端，tag 1任何已认证用户可用，tag 2仅admin可用
+func newAuthServer(t *testing.T, authenticator auth.Authenticator, cfg *tls.Config) uint16 {
+	router := job.NewJobRouter()
+	router.AddJob(1, &whoamiJob{}, job.RequireAuth())
+	router.AddJob(2, &whoamiJob{}, job.RequireRoles("admin"))
+	pool := job.NewWorkerPool(2, utils.NewBlockingQueue[common.IRequest](2), router)
+	pool.Start()
+
+	mgr := NewSessionMgr()
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+		mgr.Clear()
+		pool.Stop()
+	})
+	go func() {
+		for {
+			peer, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			var conn net.Conn = peer
+			if cfg != nil {
+				conn = tls.Server(peer, cfg)
+			}
+			s := NewSession(conn, pool, WithAuthenticator(authenticator))
+			mgr.Add(s)
+			go s.Open()
+		}
+	}()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func whoami(t *testing.T, cli *client.Client, tag uint16) (string, error) {
+	reply, err := cli.Call(context.Background(), tag, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(reply.Body), nil
+}
+
+func TestAuth_Token(t *testing.T) {
+	port := newAuthServer(t, auth.StaticTokens(map[string]*common.Principal{
+		"alice-token": {UserID: "alice", Roles: []string{"admin"}},
+		"bob-token":   {UserID: "bob"},
+	}), nil)
+
+	alice, err := client.Dial("127.0.0.1", port, client.WithCredentials(client.TokenCredentials("alice-token")))
+	require.NoError(t, err)
+	defer alice.Close()
+	assert.Equal(t, "alice", alice.UserID())
+	name, err := whoami(t, alice, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", name)
+
+	bob, err := client.Dial("127.0.0.1", port, client.WithCredentials(client.TokenCredentials("bob-token")))
+	require.NoError(t, err)
+	defer bob.Close()
+	name, err = whoami(t, bob, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", name)
+	_, err = whoami(t, bob, 2)
+	assert.ErrorContains(t, err, job.ErrForbidden.Error())
+
+	_, err = client.Dial("127.0.0.1", port, client.WithCredentials(client.TokenCredentials("bad")))
+	assert.ErrorIs(t, err, client.ErrAuthFailed)
+}
+
+func TestAuth_HMAC(t *testing.T) {
+	secret := []byte("secret")
+	port := newAuthServer(t, auth.HMAC(func(userID string) ([]byte, *common.Principal, error) {
+		if userID != "carol" {
+			return nil, nil, errors.New("unknown user")
+		}
+		return secret, &common.Principal{UserID: userID}, nil
+	}), nil)
+
+	cli, err := client.Dial("127.0.0.1", port, client.WithCredentials(client.HMACCredentials("carol", secret)))
+	require.NoError(t, err)
+	defer cli.Close()
+	name, err := whoami(t, cli, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "carol", name)
+
+	_, badSecret := client.Dial("127.0.0.1", port, client.WithCredentials(client.HMACCredentials("carol", []byte("wrong"))))
+	assert.ErrorIs(t, badSecret, client.ErrAuthFailed)
+	_, unknownUser := client.Dial("127.0.0.1", port, client.WithCredentials(client.HMACCredentials("dave", secret)))
+	assert.ErrorIs(t, unknownUser, client.ErrAuthFailed)
+	// 对端无法区分用户不存在和签名错误
+	assert.Equal(t, badSecret.Error(), unknownUser.Error())
+}
+
+// newCert 签发证书，parent为nil时自签名
+func newCert(t *testing.T, cn string, ou []string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: ou},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	issuer, signer := tmpl, any(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, signer)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestAuth_TLSCert(t *testing.T) {
+	ca := newCert(t, "ca", nil, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	port := newAuthServer(t, auth.TLSCert(nil), &tls.Config{
+		Certificates: []tls.Certificate{newCert(t, "server", nil, &ca)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+
+	cli, err := client.Dial("127.0.0.1", port, client.WithCredentials(client.CertCredentials()), client.WithTLS(&tls.Config{
+		Certificates: []tls.Certificate{newCert(t, "erin", []string{"admin"}, &ca)},
+		RootCAs:      pool,
+	}))
+	require.NoError(t, err)
+	defer cli.Close()
+	name, err := whoami(t, cli, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "erin", name)
+}
+
+func TestAuth_Timeout(t *testing.T) {
+	timeout := utils.Conf.Server.AuthTimeout
+	utils.Conf.Server.AuthTimeout = 1
+	defer func() { utils.Conf.Server.AuthTimeout = timeout }()
+	port := newAuthServer(t, auth.StaticTokens(nil), nil)
+
+	addr := (&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(port)}).String()
+	conn, err := net.Dial("tcp4", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	// 认证之前的请求不会被路由
+	send(t, conn, message.NewSeqedTLVMsg(7, 1, nil))
+	msg := recv(t, conn)
+	assert.Equal(t, uint16(job.ErrorTag), msg.Tag())
+
+	// 不发送认证消息，超时后连接被关闭
+	conn2, err := net.Dial("tcp4", addr)
+	require.NoError(t, err)
+	defer conn2.Close()
+	time.Sleep(500 * time.Millisecond)
+	msg = recv(t, conn2)
+	assert.Equal(t, uint16(job.ErrorTag), msg.Tag())
+	assert.Contains(t, string(msg.Body()), auth.ErrAuthTimeout.Error())
+}
@@ -0,0 +1,137 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Meha555/pulse/server/common"
+
+	"github.com/google/uuid"
+)
+
+// 被新会话顶替时发给旧会话的关闭原因
+const kReplacedReason = "replaced by a new session"
+
+var ErrAlreadyBound = errors.New("key is already bound to another session")
+
+// DuplicatePolicy 绑定的key已经绑定了其它会话时的处理策略
+type DuplicatePolicy int
+
+const (
+	// 踢出之前绑定的会话
+	KickOld DuplicatePolicy = iota
+	// 拒绝新的绑定
+	RejectNew
+	// 允许一个key绑定多个会话
+	AllowMultiple
+)
+
+// WithDuplicatePolicy 设置重复绑定的处理策略，默认为 KickOld
+func WithDuplicatePolicy(policy DuplicatePolicy) SessionMgrOption {
+	return func(c *SessionMgr) {
+		c.duplicatePolicy = policy
+	}
+}
+
+// Bind 将会话与业务的key（如用户ID、设备ID）绑定，一个会话可以绑定多个key。
+// key已经绑定了其它会话时按 DuplicatePolicy 处理，会话关闭后自动解绑
+func (c *SessionMgr) Bind(session common.ISession, key string) error {
+	c.mtx.Lock()
+	if _, ok := c.sessionMap[session.ID()]; !ok {
+		c.mtx.Unlock()
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, session.ID())
+	}
+	var olds []common.ISession
+	for id := range c.bindings[key] {
+		if id != session.ID() {
+			olds = append(olds, c.sessionMap[id])
+		}
+	}
+	if len(olds) > 0 {
+		switch c.duplicatePolicy {
+		case RejectNew:
+			c.mtx.Unlock()
+			return fmt.Errorf("%w: %s", ErrAlreadyBound, key)
+		case KickOld:
+			for _, old := range olds {
+				c.unbind(old.ID(), key)
+			}
+		}
+	}
+	if c.bindings[key] == nil {
+		c.bindings[key] = make(map[uuid.UUID]struct{})
+	}
+	c.bindings[key][session.ID()] = struct{}{}
+	if c.boundKeys[session.ID()] == nil {
+		c.boundKeys[session.ID()] = make(map[string]struct{})
+	}
+	c.boundKeys[session.ID()][key] = struct{}{}
+	c.mtx.Unlock()
+
+	if c.duplicatePolicy == KickOld {
+		for _, old := range olds {
+			logger.Infof("Session %s bound to %s is replaced by %s", old.ID(), key, session.ID())
+			if err := c.kick(old, kReplacedReason); err != nil {
+				logger.Warnf("kick session %s error: %v", old.ID(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Unbind 解除会话与key的绑定
+func (c *SessionMgr) Unbind(sessionID uuid.UUID, key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.unbind(sessionID, key)
+}
+
+// GetByKey 返回与key绑定的会话
+func (c *SessionMgr) GetByKey(key string) []common.ISession {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	sessions := make([]common.ISession, 0, len(c.bindings[key]))
+	for id := range c.bindings[key] {
+		sessions = append(sessions, c.sessionMap[id])
+	}
+	return sessions
+}
+
+// KeysOf 返回会话绑定的所有key
+func (c *SessionMgr) KeysOf(sessionID uuid.UUID) []string {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return sortedKeys(c.boundKeys[sessionID])
+}
+
+// KickByKey 踢出与key绑定的所有会话，返回踢出的个数
+func (c *SessionMgr) KickByKey(key, reason string) int {
+	n := 0
+	for _, session := range c.GetByKey(key) {
+		if err := c.kick(session, reason); err != nil {
+			logger.Warnf("kick session %s error: %v", session.ID(), err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// unbind 解除会话与key的绑定，调用方需持有c.mtx
+func (c *SessionMgr) unbind(sessionID uuid.UUID, key string) {
+	delete(c.bindings[key], sessionID)
+	if len(c.bindings[key]) == 0 {
+		delete(c.bindings, key)
+	}
+	delete(c.boundKeys[sessionID], key)
+	if len(c.boundKeys[sessionID]) == 0 {
+		delete(c.boundKeys, sessionID)
+	}
+}
+
+// unbindAll 解除会话的所有绑定，调用方需持有c.mtx
+func (c *SessionMgr) unbindAll(sessionID uuid.UUID) {
+	for key := range c.boundKeys[sessionID] {
+		c.unbind(sessionID, key)
+	}
+}
@@ -0,0 +1,66 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionMgr_Bind(t *testing.T) {
+	t.Run("KickOld", func(t *testing.T) {
+		mgr := NewSessionMgr()
+		defer mgr.Clear()
+		old, oldPeer := newPipeSession(t, mgr)
+		s, _ := newPipeSession(t, mgr)
+		require.NoError(t, mgr.Bind(old, "device-1"))
+		require.NoError(t, mgr.Bind(old, "user-1"))
+		require.NoError(t, mgr.Bind(s, "device-1"))
+
+		msg := recv(t, oldPeer)
+		assert.Equal(t, uint16(job.CloseTag), msg.Tag())
+		reason, text, err := job.DecodeClose(msg.Body())
+		require.NoError(t, err)
+		assert.Equal(t, common.CloseKicked, reason)
+		assert.Equal(t, kReplacedReason, text)
+		assert.Eventually(t, func() bool { return mgr.Get(old.ID()) == nil }, time.Second, 10*time.Millisecond)
+		sessions := mgr.GetByKey("device-1")
+		require.Len(t, sessions, 1)
+		assert.Equal(t, s.ID(), sessions[0].ID())
+		assert.Empty(t, mgr.GetByKey("user-1"))
+	})
+
+	t.Run("RejectNew", func(t *testing.T) {
+		mgr := NewSessionMgr(WithDuplicatePolicy(RejectNew))
+		defer mgr.Clear()
+		a, _ := newPipeSession(t, mgr)
+		b, _ := newPipeSession(t, mgr)
+		require.NoError(t, mgr.Bind(a, "user-1"))
+		require.NoError(t, mgr.Bind(a, "user-1"))
+		assert.ErrorIs(t, mgr.Bind(b, "user-1"), ErrAlreadyBound)
+
+		mgr.Unbind(a.ID(), "user-1")
+		require.NoError(t, mgr.Bind(b, "user-1"))
+		assert.Equal(t, []string{"user-1"}, mgr.KeysOf(b.ID()))
+	})
+
+	t.Run("AllowMultiple", func(t *testing.T) {
+		mgr := NewSessionMgr(WithDuplicatePolicy(AllowMultiple))
+		defer mgr.Clear()
+		a, _ := newPipeSession(t, mgr)
+		b, _ := newPipeSession(t, mgr)
+		require.NoError(t, mgr.Bind(a, "user-1"))
+		require.NoError(t, mgr.Bind(b, "user-1"))
+		assert.Len(t, mgr.GetByKey("user-1"), 2)
+
+		// 会话关闭后自动解绑
+		a.Close(common.CloseNormal)
+		assert.Eventually(t, func() bool { return len(mgr.GetByKey("user-1")) == 1 }, time.Second, 10*time.Millisecond)
+		assert.Empty(t, mgr.KeysOf(a.ID()))
+		assert.ErrorIs(t, mgr.Bind(a, "user-2"), ErrSessionNotFound)
+	})
+}
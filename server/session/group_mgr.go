@@ -0,0 +1,260 @@
+package session
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+
+	"github.com/google/uuid"
+)
+
+// Group 一组会话，如聊天室、游戏房间
+type Group struct {
+	name    string
+	members map[uuid.UUID]common.ISession
+	meta    map[string]any
+	mtx     sync.RWMutex
+}
+
+func newGroup(name string) *Group {
+	return &Group{
+		name:    name,
+		members: make(map[uuid.UUID]common.ISession),
+		meta:    make(map[string]any),
+	}
+}
+
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Members 返回所有成员
+func (g *Group) Members() []common.ISession {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+	members := make([]common.ISession, 0, len(g.members))
+	for _, session := range g.members {
+		members = append(members, session)
+	}
+	return members
+}
+
+// Len 成员个数
+func (g *Group) Len() int {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+	return len(g.members)
+}
+
+// Has 会话是否是该组的成员
+func (g *Group) Has(sessionID uuid.UUID) bool {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+	_, ok := g.members[sessionID]
+	return ok
+}
+
+// SetMeta 设置组的元数据
+func (g *Group) SetMeta(key string, value any) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.meta[key] = value
+}
+
+// Meta 获取组的元数据
+func (g *Group) Meta(key string) (any, bool) {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+	value, ok := g.meta[key]
+	return value, ok
+}
+
+// Broadcast 向组内所有成员发送消息，返回成功放入发送队列的成员个数。发送不会阻塞，发送队列已满的成员会丢弃该消息
+func (g *Group) Broadcast(msg message.IPacket) (int, error) {
+	data, err := message.Marshal(msg)
+	if err != nil {
+		return 0, fmt.Errorf("marshal msg error: %w", err)
+	}
+	sent := 0
+	for _, session := range g.Members() {
+		if err := push(session, data, msg); err != nil {
+			logger.Warnf("Session %s in group %s drop msg: %v", session.ID(), g.name, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// GroupMgr 管理会话的分组。
+// 会话加入第一个组时开始监听它的 ExitChan，会话关闭后自动退出所有组；组内没有成员时自动删除
+type GroupMgr struct {
+	groups map[string]*Group
+	// 每个会话加入的组，<会话ID, 组名集合>
+	joined map[uuid.UUID]map[string]struct{}
+	// 正在监听ExitChan的会话
+	watching map[uuid.UUID]struct{}
+	onEmpty  func(group *Group)
+	mtx      sync.Mutex
+}
+
+type GroupMgrOption func(*GroupMgr)
+
+// WithOnEmpty 设置组因最后一个成员退出而被删除时的回调，回调在锁外执行
+func WithOnEmpty(fn func(group *Group)) GroupMgrOption {
+	return func(m *GroupMgr) {
+		m.onEmpty = fn
+	}
+}
+
+func NewGroupMgr(opts ...GroupMgrOption) *GroupMgr {
+	m := &GroupMgr{
+		groups:   make(map[string]*Group),
+		joined:   make(map[uuid.UUID]map[string]struct{}),
+		watching: make(map[uuid.UUID]struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Join 将会话加入组，组不存在时创建
+func (m *GroupMgr) Join(name string, session common.ISession) *Group {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	g, ok := m.groups[name]
+	if !ok {
+		g = newGroup(name)
+		m.groups[name] = g
+	}
+	g.mtx.Lock()
+	g.members[session.ID()] = session
+	g.mtx.Unlock()
+
+	if m.joined[session.ID()] == nil {
+		m.joined[session.ID()] = make(map[string]struct{})
+	}
+	m.joined[session.ID()][name] = struct{}{}
+	if _, ok := m.watching[session.ID()]; !ok {
+		m.watching[session.ID()] = struct{}{}
+		go m.watch(session)
+	}
+	return g
+}
+
+// watch 会话关闭后退出所有组
+func (m *GroupMgr) watch(session common.ISession) {
+	<-session.ExitChan()
+	// 在同一个临界区内退出所有组并停止监视，之后的Join会重新开始监视
+	m.mtx.Lock()
+	empties := m.leaveAll(session.ID())
+	delete(m.watching, session.ID())
+	m.mtx.Unlock()
+	m.notifyEmpty(empties...)
+}
+
+// Leave 将会话移出组
+func (m *GroupMgr) Leave(name string, sessionID uuid.UUID) {
+	m.mtx.Lock()
+	empty := m.leave(name, sessionID)
+	m.mtx.Unlock()
+	m.notifyEmpty(empty)
+}
+
+// LeaveAll 将会话移出所有组
+func (m *GroupMgr) LeaveAll(sessionID uuid.UUID) {
+	m.mtx.Lock()
+	empties := m.leaveAll(sessionID)
+	m.mtx.Unlock()
+	m.notifyEmpty(empties...)
+}
+
+// leaveAll 将会话移出所有组，返回变为空而被删除的组，调用方需持有m.mtx
+func (m *GroupMgr) leaveAll(sessionID uuid.UUID) []*Group {
+	var empties []*Group
+	for name := range m.joined[sessionID] {
+		if g := m.leave(name, sessionID); g != nil {
+			empties = append(empties, g)
+		}
+	}
+	return empties
+}
+
+// leave 将会话移出组，组变为空时删除并返回该组，调用方需持有m.mtx
+func (m *GroupMgr) leave(name string, sessionID uuid.UUID) *Group {
+	if joined, ok := m.joined[sessionID]; ok {
+		delete(joined, name)
+		if len(joined) == 0 {
+			delete(m.joined, sessionID)
+		}
+	}
+	g, ok := m.groups[name]
+	if !ok {
+		return nil
+	}
+	g.mtx.Lock()
+	_, member := g.members[sessionID]
+	delete(g.members, sessionID)
+	empty := member && len(g.members) == 0
+	g.mtx.Unlock()
+	if !empty {
+		return nil
+	}
+	delete(m.groups, name)
+	return g
+}
+
+func (m *GroupMgr) notifyEmpty(groups ...*Group) {
+	for _, g := range groups {
+		if g == nil {
+			continue
+		}
+		logger.Debugf("group %s is empty, removed", g.name)
+		if m.onEmpty != nil {
+			m.onEmpty(g)
+		}
+	}
+}
+
+// Group 获取组，不存在时返回nil
+func (m *GroupMgr) Group(name string) *Group {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.groups[name]
+}
+
+// Groups 返回所有组名
+func (m *GroupMgr) Groups() []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return sortedKeys(m.groups)
+}
+
+// GroupsOf 返回会话加入的所有组名
+func (m *GroupMgr) GroupsOf(sessionID uuid.UUID) []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return sortedKeys(m.joined[sessionID])
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Broadcast 向组内所有成员发送消息，组不存在时返回0
+func (m *GroupMgr) Broadcast(name string, msg message.IPacket) (int, error) {
+	g := m.Group(name)
+	if g == nil {
+		return 0, nil
+	}
+	return g.Broadcast(msg)
+}
@@ -0,0 +1,48 @@
+package session
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupMgr(t *testing.T) {
+	// 连接断开后会话在50ms后关闭
+	mgr, addr := newResumeServer(t, 50*time.Millisecond)
+	conn1, id1, _ := dial(t, addr)
+	conn2, id2, _ := dial(t, addr)
+	require.Eventually(t, func() bool { return mgr.Count() == 2 }, time.Second, 10*time.Millisecond)
+
+	emptied := make(chan string, 2)
+	groups := NewGroupMgr(WithOnEmpty(func(g *Group) {
+		owner, _ := g.Meta("owner")
+		emptied <- fmt.Sprintf("%s:%v", g.Name(), owner)
+	}))
+	room := groups.Join("room", mgr.Get(id1))
+	room.SetMeta("owner", "alice")
+	groups.Join("room", mgr.Get(id2))
+	groups.Join("lobby", mgr.Get(id2))
+	assert.Equal(t, []string{"lobby", "room"}, groups.Groups())
+	assert.Equal(t, []string{"lobby", "room"}, groups.GroupsOf(id2))
+	assert.Equal(t, 2, room.Len())
+
+	n, err := groups.Broadcast("room", message.NewSeqedTLVMsg(0, 2, []byte("room")))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "room", string(recv(t, conn1).Body()))
+	assert.Equal(t, "room", string(recv(t, conn2).Body()))
+
+	groups.Leave("room", id1)
+	assert.False(t, room.Has(id1))
+
+	// 会话关闭后自动退出所有组，空组被删除
+	conn2.Close()
+	require.Eventually(t, func() bool { return len(groups.Groups()) == 0 }, time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, []string{"room:alice", "lobby:<nil>"}, []string{<-emptied, <-emptied})
+	assert.Empty(t, groups.GroupsOf(id2))
+}
@@ -0,0 +1,192 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+
+	"github.com/google/uuid"
+)
+
+// defaultHeartBeatPolicy 根据配置文件生成心跳策略
+func defaultHeartBeatPolicy() common.HeartBeatPolicy {
+	initiator, err := common.ParseHeartBeatInitiator(utils.Conf.Server.HeartBeatInitiator)
+	if err != nil {
+		logger.Warnf("%v, use %s", err, initiator)
+	}
+	return common.HeartBeatPolicy{
+		Initiator:  initiator,
+		Interval:   time.Duration(utils.Conf.Server.HeartBeatTick) * time.Second,
+		MaxMissed:  utils.Conf.Server.HeartBeatMaxMissed,
+		AnyTraffic: utils.Conf.Server.HeartBeatAnyTraffic,
+	}
+}
+
+// WithHeartBeatPolicy 设置心跳策略，默认由配置文件决定
+func WithHeartBeatPolicy(policy common.HeartBeatPolicy) SessionMgrOption {
+	return func(c *SessionMgr) {
+		c.heartBeatPolicy = policy
+	}
+}
+
+// startHeartBeat 在时间轮上为会话开启心跳检查，调用方需持有c.mtx
+func (c *SessionMgr) startHeartBeat(session common.ISession) {
+	s, ok := session.(*Session)
+	policy := c.heartBeatPolicy
+	if !ok || policy.Interval <= 0 {
+		return
+	}
+	s.heartBeatAnyTraffic = policy.AnyTraffic
+	if !policy.ServerSends() && policy.MaxMissed == 0 {
+		// 既不发送也不检查
+		return
+	}
+	s.heartBeatTimer.Store(utils.AfterFunc(policy.Interval, func() {
+		c.checkHeartBeat(s)
+	}))
+}
+
+// stopHeartBeat 停止会话的心跳检查
+func (c *Session) stopHeartBeat() {
+	if timer := c.heartBeatTimer.Swap(nil); timer != nil {
+		timer.Stop()
+	}
+}
+
+// sendHeartBeat 发送携带当前时间的心跳，在Writer协程中执行
+func (c *Session) sendHeartBeat() {
+	if err := c.TrySendMsg(message.NewSeqedTLVMsg(0, job.HeartBeatTag, job.EncodeTimestamp(time.Now()))); err != nil {
+		logger.Debugf("Session %s send heartbeat error: %v", c.ID(), err)
+	}
+}
+
+// checkHeartBeat 检查会话是否存活，需要时发送心跳，连续多次没有收到心跳则关闭会话。在时间轮的协程中执行，不能阻塞
+func (c *SessionMgr) checkHeartBeat(s *Session) {
+	policy := c.heartBeatPolicy
+	if !s.Detached() {
+		// 等待恢复的会话由恢复超时负责关闭
+		if policy.MaxMissed > 0 && s.HeartBeat() >= policy.MaxMissed {
+			logger.Warnf("Conn %s missed %d heartbeats, maybe offline", s.ID(), s.HeartBeat())
+			go func() {
+				s.Close(common.CloseHeartbeatTimeout)
+				c.Del(s.ID())
+			}()
+			return
+		}
+		s.heartbeat.Add(1)
+		if policy.ServerSends() {
+			// 交给Writer发送，上一次的通知还没处理时不重复通知
+			select {
+			case s.heartBeatCh <- struct{}{}:
+			default:
+			}
+		}
+	}
+	// 不访问管理器的锁，避免阻塞时间轮
+	if timer := s.heartBeatTimer.Load(); timer != nil {
+		timer.Reset(policy.Interval)
+		if s.heartBeatTimer.Load() == nil {
+			// 与 stopHeartBeat 并发，重新设置后又被停止
+			timer.Stop()
+		}
+	}
+}
+
+// UpdateHeartBeat 对端存活，清零心跳计次
+func (c *Session) UpdateHeartBeat() {
+	c.heartbeat.Store(0)
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+// HeartBeat 返回连续没有收到心跳的次数
+func (c *Session) HeartBeat() uint {
+	return uint(c.heartbeat.Load())
+}
+
+// HeartBeatStats 返回心跳统计
+func (c *Session) HeartBeatStats() common.HeartBeatStats {
+	stats := common.HeartBeatStats{
+		RTT:       time.Duration(c.rtt.Load()),
+		ClockSkew: time.Duration(c.clockSkew.Load()),
+		Missed:    c.HeartBeat(),
+	}
+	if seen := c.lastSeen.Load(); seen != 0 {
+		stats.LastSeen = time.Unix(0, seen)
+	}
+	return stats
+}
+
+// onHeartBeat 处理对端的心跳：回应带时间戳的心跳，或者根据对本端心跳的回应更新统计
+func (c *Session) onHeartBeat(msg message.ISeqedTLVMsg) {
+	now := time.Now()
+	c.UpdateHeartBeat()
+	if ack := job.HeartBeatAck(msg.Body(), now); ack != nil {
+		if err := c.TrySendMsg(message.NewSeqedTLVMsg(msg.Serial(), job.HeartBeatTag, ack)); err != nil {
+			logger.Debugf("Session %s reply heartbeat error: %v", c.ID(), err)
+		}
+		return
+	}
+	if rtt, skew, ok := job.MeasureHeartBeat(msg.Body(), now); ok {
+		c.rtt.Store(int64(rtt))
+		c.clockSkew.Store(int64(skew))
+	}
+}
+
+// Ping 向对端发起Ping并等待Pong，返回RTT。RTT同时记录在 HeartBeatStats 中
+func (c *Session) Ping(ctx context.Context) (time.Duration, error) {
+	serial := c.pingSerial.Add(1)
+	ch := make(chan time.Duration, 1)
+	c.pingMtx.Lock()
+	c.pings[serial] = ch
+	c.pingMtx.Unlock()
+	defer func() {
+		c.pingMtx.Lock()
+		delete(c.pings, serial)
+		c.pingMtx.Unlock()
+	}()
+	if err := c.SendMsg(message.NewSeqedTLVMsg(serial, job.PingTag, job.EncodeTimestamp(time.Now()))); err != nil {
+		return 0, err
+	}
+	select {
+	case rtt := <-ch:
+		return rtt, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-c.exitCh:
+		return 0, ErrSessionClosed
+	}
+}
+
+// onPong 根据对端的Pong记录RTT，并唤醒等待该Pong的 Ping
+func (c *Session) onPong(msg message.ISeqedTLVMsg) {
+	sent, err := job.DecodeTimestamp(msg.Body())
+	if err != nil {
+		logger.Debugf("Session %s parse pong error: %v", c.ID(), err)
+		return
+	}
+	rtt := time.Since(sent)
+	c.rtt.Store(int64(rtt))
+	c.pingMtx.Lock()
+	ch, ok := c.pings[msg.Serial()]
+	c.pingMtx.Unlock()
+	if ok {
+		select {
+		case ch <- rtt:
+		default:
+		}
+	}
+}
+
+// Ping 向指定的会话发起Ping并返回RTT
+func (c *SessionMgr) Ping(ctx context.Context, sessionID uuid.UUID) (time.Duration, error) {
+	s, ok := c.Get(sessionID).(*Session)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	return s.Ping(ctx)
+}
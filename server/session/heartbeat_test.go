@@ -0,0 +1,158 @@
+package session
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHeartBeatMgr 创建使用policy的SessionMgr，测试结束时清理
+func newHeartBeatMgr(t *testing.T, policy common.HeartBeatPolicy) *SessionMgr {
+	mgr := NewSessionMgr(WithHeartBeatPolicy(policy))
+	t.Cleanup(mgr.Clear)
+	return mgr
+}
+
+func TestSessionMgr_HeartBeat(t *testing.T) {
+	t.Run("Missed", func(t *testing.T) {
+		mgr := newHeartBeatMgr(t, common.HeartBeatPolicy{
+			Initiator: common.HeartBeatByServer,
+			Interval:  10 * time.Millisecond,
+			MaxMissed: 5,
+		})
+		s, peer, reasons := openTimeoutSession(t, mgr, func(*Session) {})
+		// 收到心跳但不回应。最后一次心跳可能在发出前就因超时关闭了会话，只检查第一条
+		msg := recv(t, peer)
+		require.Equal(t, uint16(job.HeartBeatTag), msg.Tag())
+		require.Len(t, msg.Body(), 8)
+		go io.Copy(io.Discard, peer)
+		expectClose(t, reasons, common.CloseHeartbeatTimeout, time.Second)
+		assert.Eventually(t, func() bool { return mgr.Get(s.ID()) == nil }, time.Second, 10*time.Millisecond)
+		assert.Nil(t, s.heartBeatTimer.Load())
+	})
+
+	t.Run("SlowHook", func(t *testing.T) {
+		mgr := newHeartBeatMgr(t, common.HeartBeatPolicy{
+			Initiator: common.HeartBeatByServer,
+			Interval:  10 * time.Millisecond,
+		})
+		block := make(chan struct{})
+		defer close(block)
+		_, peer, _ := openTimeoutSession(t, mgr, func(s *Session) {
+			BeforeSend(func(common.ISession, message.IPacket) { <-block })(s)
+		})
+		go io.Copy(io.Discard, peer)
+		// 阻塞的钩子在Writer中执行，不影响时间轮上的其它定时器
+		time.Sleep(30 * time.Millisecond)
+		fired := make(chan struct{})
+		utils.AfterFunc(10*time.Millisecond, func() { close(fired) })
+		select {
+		case <-fired:
+		case <-time.After(time.Second):
+			t.Fatal("timing wheel blocked by send hook")
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		mgr := newHeartBeatMgr(t, common.HeartBeatPolicy{
+			Initiator: common.HeartBeatByServer,
+			Interval:  10 * time.Millisecond,
+			MaxMissed: 5,
+		})
+		s, peer, reasons := openTimeoutSession(t, mgr, func(*Session) {})
+		// 对端的时钟快一个小时
+		skew := time.Hour
+		for range 10 {
+			msg := recv(t, peer)
+			ack := job.HeartBeatAck(msg.Body(), time.Now().Add(skew))
+			send(t, peer, message.NewSeqedTLVMsg(msg.Serial(), job.HeartBeatTag, ack))
+		}
+		stats := s.HeartBeatStats()
+		assert.Greater(t, stats.RTT, time.Duration(0))
+		assert.InDelta(t, float64(skew), float64(stats.ClockSkew), float64(100*time.Millisecond))
+		assert.WithinDuration(t, time.Now(), stats.LastSeen, time.Second)
+		assert.Less(t, stats.Missed, uint(5))
+		assert.Empty(t, reasons)
+	})
+
+	t.Run("ClientInitiated", func(t *testing.T) {
+		mgr := newHeartBeatMgr(t, common.HeartBeatPolicy{
+			Initiator: common.HeartBeatByClient,
+			Interval:  20 * time.Millisecond,
+			MaxMissed: 3,
+		})
+		_, peer, reasons := openTimeoutSession(t, mgr, func(*Session) {})
+		// 服务端不发送心跳，只回应对端的心跳
+		for i := range 5 {
+			sent := time.Now()
+			send(t, peer, message.NewSeqedTLVMsg(uint32(i), job.HeartBeatTag, job.EncodeTimestamp(sent)))
+			msg := recv(t, peer)
+			require.Equal(t, uint16(job.HeartBeatTag), msg.Tag())
+			require.Equal(t, uint32(i), msg.Serial())
+			rtt, _, ok := job.MeasureHeartBeat(msg.Body(), time.Now())
+			require.True(t, ok)
+			assert.Less(t, rtt, time.Since(sent)+time.Millisecond)
+			time.Sleep(20 * time.Millisecond)
+		}
+		assert.Empty(t, reasons)
+		// 不再发送心跳
+		expectClose(t, reasons, common.CloseHeartbeatTimeout, time.Second)
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		mgr := newHeartBeatMgr(t, common.HeartBeatPolicy{})
+		s, peer, _ := openTimeoutSession(t, mgr, func(*Session) {})
+		go func() {
+			// 对端原样回复Pong
+			msg := recv(t, peer)
+			time.Sleep(10 * time.Millisecond)
+			send(t, peer, message.NewSeqedTLVMsg(msg.Serial(), job.PongTag, msg.Body()))
+		}()
+		rtt, err := mgr.Ping(context.Background(), s.ID())
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, rtt, 10*time.Millisecond)
+		assert.Equal(t, rtt, s.HeartBeatStats().RTT)
+
+		// 对端不回复时等到ctx结束
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		go io.Copy(io.Discard, peer)
+		_, err = s.Ping(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("AnyTraffic", func(t *testing.T) {
+		mgr := newHeartBeatMgr(t, common.HeartBeatPolicy{
+			Initiator:  common.HeartBeatByClient,
+			Interval:   20 * time.Millisecond,
+			MaxMissed:  3,
+			AnyTraffic: true,
+		})
+		_, peer, reasons := openTimeoutSession(t, mgr, func(*Session) {})
+		go func() {
+			// 丢弃服务端的回复
+			buf := make([]byte, 1024)
+			for {
+				if _, err := peer.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+		// 只有业务消息，没有心跳
+		for range 6 {
+			send(t, peer, message.NewSeqedTLVMsg(0, 1, nil))
+			time.Sleep(20 * time.Millisecond)
+		}
+		assert.Empty(t, reasons)
+		expectClose(t, reasons, common.CloseHeartbeatTimeout, time.Second)
+	})
+}
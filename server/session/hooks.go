@@ -1,21 +1,46 @@
 package session
 
-import "github.com/Meha555/pulse/server/common"
+import (
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+)
 
 type hooks struct {
 	onOpen     hook
-	onClose    hook
-	beforeSend hook
+	onClose    closeHook
+	onAuth     authHook
+	onError    errorHook
+	beforeSend msgHook
 	beforeRecv hook
-	afterSend  hook
-	afterRecv  hook
+	afterSend  msgHook
+	afterRecv  msgHook
+	// 发送队列的高低水位
+	onHighWater hook
+	onLowWater  hook
 }
 
 type hook func(common.ISession)
+
+// closeHook 会话关闭时的钩子，reason为关闭的原因
+type closeHook func(common.ISession, common.CloseReason)
+
+// msgHook 收发消息时的钩子，msg为发送或收到的消息
+type msgHook func(common.ISession, message.IPacket)
+
+// authHook 认证通过时的钩子
+type authHook func(common.ISession, *common.Principal)
+
+// errorHook 读写出错或认证失败时的钩子
+type errorHook func(common.ISession, error)
+
 type hookOpt func(c *Session)
 
 // 定义一个空函数
 var noOp hook = func(common.ISession) {}
+var noOpClose closeHook = func(common.ISession, common.CloseReason) {}
+var noOpMsg msgHook = func(common.ISession, message.IPacket) {}
+var noOpAuth authHook = func(common.ISession, *common.Principal) {}
+var noOpError errorHook = func(common.ISession, error) {}
 
 func OnOpen(f hook) hookOpt {
 	return func(c *Session) {
@@ -23,13 +48,28 @@ func OnOpen(f hook) hookOpt {
 	}
 }
 
-func OnClose(f hook) hookOpt {
+func OnClose(f closeHook) hookOpt {
 	return func(c *Session) {
 		c.hookStub.onClose = f
 	}
 }
 
-func BeforeSend(f hook) hookOpt {
+// OnAuth 认证通过后调用，此时读写协程尚未启动
+func OnAuth(f authHook) hookOpt {
+	return func(c *Session) {
+		c.hookStub.onAuth = f
+	}
+}
+
+// OnError 读写出错或认证失败时调用，对端正常断开（EOF）不算错误
+func OnError(f errorHook) hookOpt {
+	return func(c *Session) {
+		c.hookStub.onError = f
+	}
+}
+
+// BeforeSend 消息序列化并放入发送队列之前调用，会话已经关闭或正在关闭时不调用。之后消息仍可能因队列已满而没能放入
+func BeforeSend(f msgHook) hookOpt {
 	return func(c *Session) {
 		c.hookStub.beforeSend = f
 	}
@@ -41,14 +81,81 @@ func BeforeRecv(f hook) hookOpt {
 	}
 }
 
-func AfterSend(f hook) hookOpt {
+// AfterSend 消息成功放入发送队列之后调用，不代表已经写到连接上
+func AfterSend(f msgHook) hookOpt {
 	return func(c *Session) {
 		c.hookStub.afterSend = f
 	}
 }
 
-func AfterRecv(f hook) hookOpt {
+// AfterRecv 成功收到一条完整的消息后调用
+func AfterRecv(f msgHook) hookOpt {
 	return func(c *Session) {
 		c.hookStub.afterRecv = f
 	}
 }
+
+// Hooks 会话生命周期的钩子，各字段均可以为nil。
+// 钩子在会话的读写协程或调用方的协程中同步执行，不能阻塞
+type Hooks struct {
+	// 会话开始工作（认证通过）后、读写协程启动前调用
+	OnConnect func(session common.ISession)
+	// 认证通过后调用
+	OnAuth func(session common.ISession, principal *common.Principal)
+	// 会话关闭时调用
+	OnDisconnect func(session common.ISession, reason common.CloseReason)
+	// 收到消息后、交给路由之前调用，包括心跳等系统消息，不包括认证消息
+	OnMessage func(session common.ISession, msg message.ISeqedTLVMsg)
+	// 消息成功放入发送队列之后调用，包括心跳等系统消息，没能放入的消息不会调用
+	OnSend func(session common.ISession, msg message.IPacket)
+	// 读写出错或认证失败时调用
+	OnError func(session common.ISession, err error)
+	// 发送队列达到高水位时调用，业务应暂停向该会话生产消息
+	OnHighWater func(session common.ISession)
+	// 发送队列从高水位降到低水位以下时调用，业务可以恢复生产
+	OnLowWater func(session common.ISession)
+}
+
+// WithHooks 设置一组钩子，与已经设置的同类钩子都会执行，先执行已有的
+func WithHooks(h Hooks) hookOpt {
+	return func(c *Session) {
+		stub := &c.hookStub
+		if f := h.OnConnect; f != nil {
+			prev := stub.onOpen
+			stub.onOpen = func(s common.ISession) { prev(s); f(s) }
+		}
+		if f := h.OnAuth; f != nil {
+			prev := stub.onAuth
+			stub.onAuth = func(s common.ISession, p *common.Principal) { prev(s, p); f(s, p) }
+		}
+		if f := h.OnDisconnect; f != nil {
+			prev := stub.onClose
+			stub.onClose = func(s common.ISession, r common.CloseReason) { prev(s, r); f(s, r) }
+		}
+		if f := h.OnMessage; f != nil {
+			prev := stub.afterRecv
+			stub.afterRecv = func(s common.ISession, msg message.IPacket) {
+				prev(s, msg)
+				if m, ok := msg.(message.ISeqedTLVMsg); ok {
+					f(s, m)
+				}
+			}
+		}
+		if f := h.OnSend; f != nil {
+			prev := stub.afterSend
+			stub.afterSend = func(s common.ISession, msg message.IPacket) { prev(s, msg); f(s, msg) }
+		}
+		if f := h.OnError; f != nil {
+			prev := stub.onError
+			stub.onError = func(s common.ISession, err error) { prev(s, err); f(s, err) }
+		}
+		if f := h.OnHighWater; f != nil {
+			prev := stub.onHighWater
+			stub.onHighWater = func(s common.ISession) { prev(s); f(s) }
+		}
+		if f := h.OnLowWater; f != nil {
+			prev := stub.onLowWater
+			stub.onLowWater = func(s common.ISession) { prev(s); f(s) }
+		}
+	}
+}
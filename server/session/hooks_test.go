@@ -0,0 +1,71 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/auth"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_Hooks(t *testing.T) {
+	var (
+		mtx    sync.Mutex
+		events []string
+	)
+	record := func(format string, args ...any) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		events = append(events, fmt.Sprintf(format, args...))
+	}
+	hooks := Hooks{
+		OnConnect: func(session common.ISession) { record("connect") },
+		OnAuth: func(session common.ISession, principal *common.Principal) {
+			record("auth %s", principal.UserID)
+		},
+		OnDisconnect: func(session common.ISession, reason common.CloseReason) { record("disconnect %s", reason) },
+		OnMessage: func(session common.ISession, msg message.ISeqedTLVMsg) {
+			record("recv %d", msg.Tag())
+		},
+		OnSend: func(session common.ISession, msg message.IPacket) {
+			record("send %d", msg.(message.ITLVMsg).Tag())
+		},
+		OnError: func(session common.ISession, err error) { record("error %v", errors.Is(err, ErrPacketTooLarge)) },
+	}
+	_, peer, reasons := openTimeoutSession(t, nil, func(s *Session) {
+		s.authenticator = auth.StaticTokens(map[string]*common.Principal{"token": {UserID: "alice"}})
+		WithHooks(hooks)(s)
+		WithMaxPacketSize(4096)(s)
+	})
+
+	send(t, peer, message.NewSeqedTLVMsg(0, job.AuthTag, []byte("token")))
+	require.Equal(t, uint16(job.AuthTag), recv(t, peer).Tag())
+	send(t, peer, message.NewSeqedTLVMsg(1, 1, nil))
+	require.Equal(t, uint16(1), recv(t, peer).Tag())
+
+	data, err := message.Marshal(message.NewSeqedTLVMsg(2, 1, make([]byte, 4097)))
+	require.NoError(t, err)
+	go peer.Write(data)
+	require.Equal(t, uint16(job.CloseTag), recv(t, peer).Tag())
+	expectClose(t, reasons, common.CloseProtocolError, time.Second)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	// 认证消息不经过收发钩子，OnConnect先于收到的第一条业务消息
+	assert.Equal(t, []string{
+		"auth alice",
+		"connect",
+		"recv 1",
+		"send 1",
+		"error true",
+		"disconnect protocol error",
+	}, events)
+}
@@ -0,0 +1,268 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// 踢出会话时等待已排队消息发出的超时时间
+	kKickFlushTimeout = time.Second
+)
+
+var (
+	ErrSendQueueFull   = errors.New("send queue is full")
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SlowConsumerPolicy 广播时会话的发送队列已满的处理策略
+type SlowConsumerPolicy int
+
+const (
+	// 丢弃发给该会话的消息
+	DropMessage SlowConsumerPolicy = iota
+	// 踢出该会话
+	KickSlowConsumer
+)
+
+type SessionMgrOption func(*SessionMgr)
+
+// WithSlowConsumerPolicy 设置广播时发送队列已满的处理策略，默认为 DropMessage
+func WithSlowConsumerPolicy(policy SlowConsumerPolicy) SessionMgrOption {
+	return func(c *SessionMgr) {
+		c.slowConsumerPolicy = policy
+	}
+}
+
+// TrySendMsg 同 SendMsg，但策略为 OverflowBlock 时发送队列已满不阻塞，而是返回 ErrSendQueueFull
+func (c *Session) TrySendMsg(msg message.IPacket) error {
+	data, err := message.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.touchIfApp(msg)
+	return c.trySend(data, msg)
+}
+
+// trySend 不阻塞地将已序列化的消息放入发送队列，data是msg序列化后的结果
+func (c *Session) trySend(data []byte, msg message.IPacket) error {
+	if c.isClosed.Load() || c.closing.Load() {
+		return ErrSessionClosed
+	}
+	c.hookStub.beforeSend(c, msg)
+	if err := c.enqueue(data, false); err != nil {
+		return err
+	}
+	c.hookStub.afterSend(c, msg)
+	return nil
+}
+
+// enqueue 将已序列化的消息放入发送队列，wait为false时不等待队列腾出空间
+func (c *Session) enqueue(data []byte, wait bool) error {
+	highWater, disconnect, err := c.sendQueue.push(data, wait, c.detached.Load())
+	if highWater {
+		c.hookStub.onHighWater(c)
+	}
+	if disconnect {
+		logger.Warnf("Session %s send queue overflow, disconnect it: %v", c.ID(), err)
+		c.Disconnect(common.CloseSlowConsumer, "send queue overflow")
+	}
+	return err
+}
+
+// Writable 发送队列是否未达到高水位
+func (c *Session) Writable() bool {
+	return !c.sendQueue.aboveHighWater()
+}
+
+// SendQueueLen 返回发送队列中排队的消息数和字节数
+func (c *Session) SendQueueLen() (msgs, bytes int) {
+	return c.sendQueue.len()
+}
+
+// closeFrame 优雅关闭时最后发出的关闭消息
+type closeFrame struct {
+	reason common.CloseReason
+	data   []byte
+}
+
+// Kick 以 common.CloseKicked 优雅关闭会话，reason为通知客户端的说明
+func (c *Session) Kick(reason string) error {
+	logger.Infof("Session %s is kicked: %s", c.ID(), reason)
+	return c.Disconnect(common.CloseKicked, reason)
+}
+
+// Disconnect 优雅关闭会话：发出已排队的消息和携带关闭码、说明的 job.CloseTag 消息后以reason关闭会话。
+// 对端长时间不读取时，最多等待 kKickFlushTimeout 后强制关闭
+func (c *Session) Disconnect(reason common.CloseReason, msg string) error {
+	if !c.closing.CompareAndSwap(false, true) || c.isClosed.Load() {
+		return ErrSessionClosed
+	}
+	data, err := message.Marshal(message.NewSeqedTLVMsg(0, job.CloseTag, job.EncodeClose(reason, msg)))
+	if err != nil {
+		c.Close(reason)
+		return err
+	}
+	if c.detached.Load() {
+		// 没有可用的连接，直接关闭
+		c.Close(reason)
+		return nil
+	}
+	c.closingCh <- closeFrame{reason: reason, data: data}
+	// Writer可能阻塞在写上或者尚未启动，不能无限等待。会话关闭时停止
+	c.mtx.Lock()
+	if !c.isClosed.Load() {
+		c.closeTimer = utils.AfterFunc(2*kKickFlushTimeout, func() {
+			go c.Close(reason)
+		})
+	}
+	c.mtx.Unlock()
+	return nil
+}
+
+// flush 在限定时间内发出发送队列中的消息和最后的data，由Writer协程在关闭前调用
+func (c *Session) flush(data []byte) {
+	conn := c.Conn()
+	conn.SetWriteDeadline(time.Now().Add(kKickFlushTimeout))
+	for {
+		queued, _, ok := c.sendQueue.pop()
+		if !ok {
+			break
+		}
+		if _, err := conn.Write(queued); err != nil {
+			logger.Warnf("Session %s flush error: %v", c.ID(), err)
+			return
+		}
+	}
+	if _, err := conn.Write(data); err != nil {
+		logger.Warnf("Session %s flush error: %v", c.ID(), err)
+	}
+}
+
+// Range 遍历所有会话，fn返回false时停止。fn在锁外执行，可以调用 SessionMgr 的其它方法
+func (c *SessionMgr) Range(fn func(session common.ISession) bool) {
+	c.mtx.RLock()
+	sessions := make([]common.ISession, 0, len(c.sessionMap))
+	for _, session := range c.sessionMap {
+		sessions = append(sessions, session)
+	}
+	c.mtx.RUnlock()
+	for _, session := range sessions {
+		if !fn(session) {
+			return
+		}
+	}
+}
+
+// Broadcast 向所有会话发送消息，返回成功放入发送队列的会话个数。
+// 发送不会阻塞，发送队列已满的会话按 SlowConsumerPolicy 处理
+func (c *SessionMgr) Broadcast(msg message.IPacket) (int, error) {
+	return c.SendIf(msg, nil)
+}
+
+// Multicast 向指定的会话发送消息，不存在的会话被忽略
+func (c *SessionMgr) Multicast(ids []uuid.UUID, msg message.IPacket) (int, error) {
+	data, err := message.Marshal(msg)
+	if err != nil {
+		return 0, fmt.Errorf("marshal msg error: %w", err)
+	}
+	c.mtx.RLock()
+	sessions := make([]common.ISession, 0, len(ids))
+	seen := make(map[uuid.UUID]struct{}, len(ids))
+	for _, id := range ids {
+		if _, dup := seen[id]; dup {
+			continue
+		}
+		seen[id] = struct{}{}
+		if session, ok := c.sessionMap[id]; ok {
+			sessions = append(sessions, session)
+		}
+	}
+	c.mtx.RUnlock()
+	return c.sendAll(sessions, data, msg), nil
+}
+
+// SendIf 向filter返回true的会话发送消息，filter为nil时发送给所有会话
+func (c *SessionMgr) SendIf(msg message.IPacket, filter func(session common.ISession) bool) (int, error) {
+	// 只序列化一次
+	data, err := message.Marshal(msg)
+	if err != nil {
+		return 0, fmt.Errorf("marshal msg error: %w", err)
+	}
+	var sessions []common.ISession
+	c.Range(func(session common.ISession) bool {
+		if filter == nil || filter(session) {
+			sessions = append(sessions, session)
+		}
+		return true
+	})
+	return c.sendAll(sessions, data, msg), nil
+}
+
+// sendAll 在锁外向各会话发送已序列化的消息，返回成功放入发送队列的会话个数
+func (c *SessionMgr) sendAll(sessions []common.ISession, data []byte, msg message.IPacket) int {
+	sent := 0
+	for _, session := range sessions {
+		err := push(session, data, msg)
+		switch {
+		case err == nil:
+			sent++
+		case errors.Is(err, ErrSendQueueFull) && c.slowConsumerPolicy == KickSlowConsumer:
+			logger.Warnf("Session %s is too slow, kick it", session.ID())
+			c.Kick(session.ID(), "too slow")
+		default:
+			logger.Warnf("Session %s drop msg: %v", session.ID(), err)
+		}
+	}
+	return sent
+}
+
+// push 不阻塞地向会话发送消息，data是msg序列化后的结果
+func push(session common.ISession, data []byte, msg message.IPacket) error {
+	if s, ok := session.(*Session); ok {
+		return s.trySend(data, msg)
+	}
+	return session.TrySendMsg(msg)
+}
+
+// Kick 发送携带原因的关闭消息后断开指定的会话
+func (c *SessionMgr) Kick(sessionID uuid.UUID, reason string) error {
+	return c.Disconnect(sessionID, common.CloseKicked, reason)
+}
+
+// Disconnect 发送携带关闭码和说明的关闭消息后以reason断开指定的会话
+func (c *SessionMgr) Disconnect(sessionID uuid.UUID, reason common.CloseReason, msg string) error {
+	session := c.Get(sessionID)
+	if session == nil {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	return c.disconnect(session, reason, msg)
+}
+
+// kick 发送携带原因的关闭消息后断开连接
+func (c *SessionMgr) kick(session common.ISession, reason string) error {
+	return c.disconnect(session, common.CloseKicked, reason)
+}
+
+// disconnect 发送携带关闭码和说明的关闭消息后断开连接
+func (c *SessionMgr) disconnect(session common.ISession, reason common.CloseReason, msg string) error {
+	if s, ok := session.(*Session); ok {
+		return s.Disconnect(reason, msg)
+	}
+	if err := session.TrySendMsg(message.NewSeqedTLVMsg(0, job.CloseTag, job.EncodeClose(reason, msg))); err != nil {
+		logger.Warnf("Session %s send close msg error: %v", session.ID(), err)
+	}
+	c.mtx.Lock()
+	c.remove(session)
+	c.mtx.Unlock()
+	session.Close(reason)
+	return nil
+}
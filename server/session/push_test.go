@@ -0,0 +1,65 @@
+package session
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionMgr_Push(t *testing.T) {
+	mgr, addr := newResumeServer(t, time.Second)
+	conn1, id1, _ := dial(t, addr)
+	conn2, id2, _ := dial(t, addr)
+	require.Eventually(t, func() bool { return mgr.Count() == 2 }, time.Second, 10*time.Millisecond)
+
+	n, err := mgr.Broadcast(message.NewSeqedTLVMsg(0, 2, []byte("all")))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "all", string(recv(t, conn1).Body()))
+	assert.Equal(t, "all", string(recv(t, conn2).Body()))
+
+	n, err = mgr.Multicast([]uuid.UUID{id2, uuid.New()}, message.NewSeqedTLVMsg(0, 2, []byte("some")))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "some", string(recv(t, conn2).Body()))
+
+	var ids []uuid.UUID
+	mgr.Range(func(session common.ISession) bool {
+		ids = append(ids, session.ID())
+		return true
+	})
+	assert.ElementsMatch(t, []uuid.UUID{id1, id2}, ids)
+
+	// 被踢出的连接先收到已排队的消息，然后是关闭消息
+	require.NoError(t, mgr.Get(id1).SendMsg(message.NewSeqedTLVMsg(0, 2, []byte("last"))))
+	require.NoError(t, mgr.Kick(id1, "bye"))
+	assert.Equal(t, "last", string(recv(t, conn1).Body()))
+	msg := recv(t, conn1)
+	assert.Equal(t, uint16(job.CloseTag), msg.Tag())
+	reason, text, err := job.DecodeClose(msg.Body())
+	require.NoError(t, err)
+	assert.Equal(t, common.CloseKicked, reason)
+	assert.Equal(t, "bye", text)
+	_, err = conn1.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Eventually(t, func() bool { return mgr.Get(id1) == nil }, time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, mgr.Kick(id1, "bye"), ErrSessionNotFound)
+}
+
+func TestSession_TrySendMsg(t *testing.T) {
+	// 没有Writer协程的会话，发送队列满后不阻塞
+	s := NewSession(nil, nil)
+	for range utils.Conf.Server.MaxMsgQueueSize {
+		require.NoError(t, s.TrySendMsg(message.NewSeqedTLVMsg(0, 2, nil)))
+	}
+	assert.ErrorIs(t, s.TrySendMsg(message.NewSeqedTLVMsg(0, 2, nil)), ErrSendQueueFull)
+}
@@ -34,6 +34,13 @@ func (r *Request) Msg() message.ISeqedTLVMsg {
 	return r.msg
 }
 
+func (r *Request) Principal() *common.Principal {
+	if r.session == nil {
+		return nil
+	}
+	return r.session.Principal()
+}
+
 func (r *Request) Set(key string, value interface{}) {
 	r.valueCtx = context.WithValue(r.valueCtx, key, value)
 }
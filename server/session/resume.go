@@ -0,0 +1,165 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+)
+
+// 会话恢复：
+// 1. 开启会话恢复后，服务端在连接建立时下发 job.HandshakeTag 消息，携带会话ID和恢复令牌
+// 2. 连接断开后会话不会立即关闭，而是进入detached状态并保留在 SessionMgr 中，期间发出的消息暂存在发送队列中
+// 3. 客户端重连后发送携带恢复令牌的 job.ResumeTag 消息，新连接被移交给原会话，暂存的消息随之发出
+// 4. 超过等待时间仍未恢复的会话会被关闭
+
+var (
+	ErrInvalidResumeToken = errors.New("invalid resume token")
+	ErrResumeTimeout      = errors.New("session resume timeout")
+	ErrResumeBufferFull   = errors.New("resume buffer is full")
+)
+
+// newResumeToken 生成随机的恢复令牌
+func newResumeToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// handshake 直接向连接写出握手消息
+func (c *Session) handshake() error {
+	return c.writeDirect(message.NewSeqedTLVMsg(0, job.HandshakeTag, job.EncodeHandshake(c.ID(), c.resumeToken)))
+}
+
+// Detached 连接是否已断开并正在等待客户端恢复
+func (c *Session) Detached() bool {
+	return c.detached.Load()
+}
+
+// detach 连接断开时使会话进入等待恢复的状态，未开启会话恢复或会话已关闭时返回false
+func (c *Session) detach() bool {
+	if c.mgr == nil || c.isClosed.Load() {
+		return false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.detached.Store(true)
+	close(c.connDone)
+	c.conn.Close()
+	c.graceTimer = utils.AfterFunc(c.mgr.gracePeriod, func() {
+		logger.Warnf("Session %s is not resumed in %v, close it", c.ID(), c.mgr.gracePeriod)
+		// 关闭会执行钩子，不能阻塞时间轮
+		go c.Close(common.CloseResumeTimeout)
+	})
+	return true
+}
+
+// reattach 将新连接交给等待恢复的会话，并重新启动读写协程
+func (c *Session) reattach(conn net.Conn) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.isClosed.Load() || !c.detached.Load() {
+		return fmt.Errorf("session %s is not waiting for resume", c.ID())
+	}
+	if !c.graceTimer.Stop() {
+		// 定时器已经触发，会话正在关闭
+		return ErrResumeTimeout
+	}
+	c.conn = conn
+	c.heartbeat.Store(0)
+	c.detached.Store(false)
+	c.start()
+	return nil
+}
+
+// resume 处理客户端的恢复请求，成功时当前连接被移交给原会话，当前会话作废并返回true。
+// 失败时以 job.ErrorTag 回复，客户端继续使用当前会话（握手消息已经下发）
+func (c *Session) resume(msg message.ISeqedTLVMsg) bool {
+	old, err := c.mgr.lookupResumeToken(string(msg.Body()))
+	if err == nil && !samePrincipal(old.Principal(), c.Principal()) {
+		// 只能恢复同一用户的会话
+		err = ErrInvalidResumeToken
+	}
+	if err == nil {
+		// 移交之前停止当前会话的Writer，避免两个会话同时写同一个连接
+		c.stopWriter()
+		if err = old.reattach(c.Conn()); err != nil {
+			c.mtx.Lock()
+			c.startWriter()
+			c.mtx.Unlock()
+		}
+	}
+	if err != nil {
+		logger.Warnf("Session %s resume failed: %v", c.ID(), err)
+		if err := c.SendMsg(message.NewSeqedTLVMsg(msg.Serial(), job.ErrorTag, []byte(err.Error()))); err != nil {
+			logger.Errorf("Session %s reply resume error: %v", c.ID(), err)
+		}
+		return false
+	}
+	c.release()
+	// 旧令牌可能已经泄露，恢复后换发新令牌
+	token := c.mgr.rotateResumeToken(old)
+	// 确认消息排在暂存的消息之后发出
+	if err := old.SendMsg(message.NewSeqedTLVMsg(msg.Serial(), job.ResumeTag, job.EncodeHandshake(old.ID(), token))); err != nil {
+		logger.Errorf("Session %s send resume ack error: %v", old.ID(), err)
+	}
+	logger.Infof("Session %s resumed from %s", old.ID(), old.Conn().RemoteAddr())
+	return true
+}
+
+// samePrincipal 判断两个身份是否属于同一用户
+func samePrincipal(a, b *common.Principal) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.UserID == b.UserID
+}
+
+// release 放弃连接（已移交给其它会话）并以 common.CloseResumed 关闭，与 Close 不同的是不会关闭连接
+func (c *Session) release() {
+	c.shutdown(common.CloseResumed, false)
+}
+
+// enableResume 为会话签发恢复令牌，调用方需持有c.mtx
+func (c *SessionMgr) enableResume(s *Session) {
+	s.mgr = c
+	s.resumeToken = newResumeToken()
+	c.resumeTokens[s.resumeToken] = s.ID()
+}
+
+// rotateResumeToken 为恢复成功的会话换发新的恢复令牌，旧令牌作废
+func (c *SessionMgr) rotateResumeToken(s *Session) string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.sessionMap[s.ID()]; !ok {
+		// 会话已经被移除
+		return s.resumeToken
+	}
+	delete(c.resumeTokens, s.resumeToken)
+	s.resumeToken = newResumeToken()
+	c.resumeTokens[s.resumeToken] = s.ID()
+	return s.resumeToken
+}
+
+// lookupResumeToken 查找恢复令牌对应的会话
+func (c *SessionMgr) lookupResumeToken(token string) (*Session, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	id, ok := c.resumeTokens[token]
+	if !ok {
+		return nil, ErrInvalidResumeToken
+	}
+	s, ok := c.sessionMap[id].(*Session)
+	if !ok {
+		return nil, ErrInvalidResumeToken
+	}
+	return s, nil
+}
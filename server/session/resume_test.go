@@ -0,0 +1,148 @@
+package session
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sessionIDJob 回复处理请求的会话ID
+type sessionIDJob struct {
+	job.BaseJob
+}
+
+func (j *sessionIDJob) Handle(req common.IRequest) error {
+	id := req.Session().ID()
+	return req.Session().SendMsg(message.NewSeqedTLVMsg(req.Msg().Serial(), req.Msg().Tag(), id[:]))
+}
+
+// newResumeServer 启动开启了会话恢复的测试服务端
+func newResumeServer(t *testing.T, grace time.Duration) (*SessionMgr, string) {
+	router := job.NewJobRouter()
+	require.NoError(t, router.Register(1, &sessionIDJob{}))
+	pool := job.NewWorkerPool(2, utils.NewBlockingQueue[common.IRequest](2), router)
+	pool.Start()
+
+	mgr := NewSessionMgr()
+	mgr.gracePeriod = grace
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+		mgr.Clear()
+		pool.Stop()
+	})
+	go func() {
+		for {
+			conn, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			s := NewSession(conn, pool)
+			mgr.Add(s)
+			go s.Open()
+		}
+	}()
+	return mgr, listener.Addr().String()
+}
+
+func dial(t *testing.T, addr string) (net.Conn, uuid.UUID, string) {
+	conn, err := net.Dial("tcp4", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	msg := recv(t, conn)
+	require.Equal(t, uint16(job.HandshakeTag), msg.Tag())
+	id, token, err := job.DecodeHandshake(msg.Body())
+	require.NoError(t, err)
+	return conn, id, token
+}
+
+func send(t *testing.T, conn net.Conn, msg *message.SeqedTLVMsg) {
+	data, err := message.Marshal(msg)
+	require.NoError(t, err)
+	_, err = conn.Write(data)
+	require.NoError(t, err)
+}
+
+func recv(t *testing.T, conn net.Conn) *message.SeqedTLVMsg {
+	msg := &message.SeqedTLVMsg{}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	header := make([]byte, msg.HeaderLen())
+	_, err := io.ReadFull(conn, header)
+	require.NoError(t, err)
+	require.NoError(t, message.Unmarshal(header, msg, false))
+	if msg.BodyLen() > 0 {
+		body := make([]byte, msg.BodyLen())
+		_, err = io.ReadFull(conn, body)
+		require.NoError(t, err)
+		require.NoError(t, message.UmarshalBodyOnly(body, int(msg.BodyLen()), msg))
+	}
+	return msg
+}
+
+func TestSession_Resume(t *testing.T) {
+	mgr, addr := newResumeServer(t, 200*time.Millisecond)
+
+	conn, id, token := dial(t, addr)
+	conn.Close()
+	require.Eventually(t, func() bool {
+		s, ok := mgr.Get(id).(*Session)
+		return ok && s.Detached()
+	}, time.Second, 10*time.Millisecond)
+
+	// 等待恢复期间发出的消息被暂存
+	require.NoError(t, mgr.Get(id).SendMsg(message.NewSeqedTLVMsg(0, 2, []byte("buffered"))))
+
+	t.Run("Invalid token", func(t *testing.T) {
+		conn, _, _ := dial(t, addr)
+		send(t, conn, message.NewSeqedTLVMsg(1, job.ResumeTag, []byte("bad")))
+		msg := recv(t, conn)
+		assert.Equal(t, uint16(job.ErrorTag), msg.Tag())
+		assert.Equal(t, uint32(1), msg.Serial())
+	})
+
+	conn, newID, _ := dial(t, addr)
+	assert.NotEqual(t, id, newID)
+	tmp, ok := mgr.Get(newID).(*Session)
+	require.True(t, ok)
+	tmp.Attrs().Set("k", "v")
+	send(t, conn, message.NewSeqedTLVMsg(7, job.ResumeTag, []byte(token)))
+	msg := recv(t, conn)
+	assert.Equal(t, "buffered", string(msg.Body()))
+	msg = recv(t, conn)
+	assert.Equal(t, uint16(job.ResumeTag), msg.Tag())
+	assert.Equal(t, uint32(7), msg.Serial())
+	resumed, newToken, err := job.DecodeHandshake(msg.Body())
+	require.NoError(t, err)
+	assert.Equal(t, id, resumed)
+	assert.NotEqual(t, token, newToken)
+
+	// 新连接上的请求由原会话处理，临时创建的会话被移除
+	send(t, conn, message.NewSeqedTLVMsg(8, 1, nil))
+	msg = recv(t, conn)
+	assert.Equal(t, id[:], msg.Body())
+	assert.Eventually(t, func() bool { return mgr.Get(newID) == nil }, time.Second, 10*time.Millisecond)
+	// 临时会话以 CloseResumed 关闭，属性被清空
+	assert.Equal(t, common.CloseResumed, tmp.CloseReason())
+	_, ok = tmp.Attrs().Get("k")
+	assert.False(t, ok)
+
+	// 恢复后旧令牌作废
+	other, _, _ := dial(t, addr)
+	send(t, other, message.NewSeqedTLVMsg(9, job.ResumeTag, []byte(token)))
+	assert.Equal(t, uint16(job.ErrorTag), recv(t, other).Tag())
+
+	// 超过等待时间未恢复的会话被关闭
+	conn.Close()
+	assert.Eventually(t, func() bool { return mgr.Get(id) == nil }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,291 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Meha555/pulse/utils"
+)
+
+var (
+	// ErrSessionClosed 会话已经关闭或正在优雅关闭
+	ErrSessionClosed = errors.New("connection is closed")
+	// ErrSendTimeout 发送队列已满，等待超过 SendQueueLimits.BlockTimeout
+	ErrSendTimeout = errors.New("send queue wait timeout")
+)
+
+// OverflowPolicy 发送队列超过限制时的处理策略
+type OverflowPolicy int
+
+const (
+	// 阻塞等待Writer发出消息腾出空间，超过 SendQueueLimits.BlockTimeout 返回 ErrSendTimeout。
+	// TrySendMsg 和广播不会等待，直接返回 ErrSendQueueFull
+	OverflowBlock OverflowPolicy = iota
+	// 丢弃新消息，返回 ErrSendQueueFull
+	OverflowDropNewest
+	// 丢弃队列中最早的消息，为新消息腾出空间
+	OverflowDropOldest
+	// 以 common.CloseSlowConsumer 优雅关闭会话，返回 ErrSendQueueFull
+	OverflowDisconnect
+)
+
+var overflowPolicyNames = [...]string{
+	OverflowBlock:      "block",
+	OverflowDropNewest: "drop_newest",
+	OverflowDropOldest: "drop_oldest",
+	OverflowDisconnect: "disconnect",
+}
+
+func (p OverflowPolicy) String() string {
+	if p < 0 || int(p) >= len(overflowPolicyNames) {
+		return "unknown"
+	}
+	return overflowPolicyNames[p]
+}
+
+// ParseOverflowPolicy 解析配置中的 "block"、"drop_newest"、"drop_oldest"、"disconnect"
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for i, name := range overflowPolicyNames {
+		if name == s {
+			return OverflowPolicy(i), nil
+		}
+	}
+	return OverflowBlock, fmt.Errorf("unknown overflow policy %q", s)
+}
+
+// SendQueueLimits 发送队列的限制，消息数和字节数任意一个超过限制即视为已满。
+// 队列为空时总能放入一条消息，即使它本身超过了字节数限制
+type SendQueueLimits struct {
+	// 最多排队的消息数，0表示不限制
+	MaxMsgs int
+	// 最多排队的字节数，0表示不限制
+	MaxBytes int
+	// 超过限制时的处理策略
+	Policy OverflowPolicy
+	// OverflowBlock 时最长的等待时间，0表示一直等到有空间或会话关闭
+	BlockTimeout time.Duration
+	// 排队的字节数达到HighWater时调用 OnHighWater，之后降到LowWater以下时调用 OnLowWater。HighWater为0表示不通知
+	HighWater int
+	LowWater  int
+}
+
+// SendQueueError 消息没能放入发送队列，Err为 ErrSendQueueFull、ErrSendTimeout 或 ErrResumeBufferFull
+type SendQueueError struct {
+	Policy OverflowPolicy
+	// 出错时队列中的消息数和字节数
+	Msgs  int
+	Bytes int
+	Err   error
+}
+
+func (e *SendQueueError) Error() string {
+	return fmt.Sprintf("%v (policy %s, %d msgs, %d bytes queued)", e.Err, e.Policy, e.Msgs, e.Bytes)
+}
+
+func (e *SendQueueError) Unwrap() error {
+	return e.Err
+}
+
+// defaultSendQueueLimits 根据配置文件生成发送队列的限制，高低水位为字节数限制的3/4和1/4
+func defaultSendQueueLimits() SendQueueLimits {
+	policy, err := ParseOverflowPolicy(utils.Conf.Server.SendOverflowPolicy)
+	if err != nil {
+		logger.Warnf("%v, use %s", err, policy)
+	}
+	maxBytes := int(utils.Conf.Server.MaxSendQueueBytes)
+	return SendQueueLimits{
+		MaxMsgs:      int(utils.Conf.Server.MaxMsgQueueSize),
+		MaxBytes:     maxBytes,
+		Policy:       policy,
+		BlockTimeout: time.Duration(utils.Conf.Server.SendBlockTimeout) * time.Second,
+		HighWater:    maxBytes * 3 / 4,
+		LowWater:     maxBytes / 4,
+	}
+}
+
+// WithSendQueue 设置发送队列的限制和溢出策略，默认由配置文件决定
+func WithSendQueue(limits SendQueueLimits) hookOpt {
+	return func(c *Session) {
+		c.sendQueue.limits = limits
+	}
+}
+
+// OnHighWater 排队的字节数达到高水位时调用，业务可以据此暂停向该会话生产消息
+func OnHighWater(f hook) hookOpt {
+	return func(c *Session) {
+		c.hookStub.onHighWater = f
+	}
+}
+
+// OnLowWater 达到高水位后，排队的字节数降到低水位以下时调用，在Writer协程中执行
+func OnLowWater(f hook) hookOpt {
+	return func(c *Session) {
+		c.hookStub.onLowWater = f
+	}
+}
+
+// sendQueue 有界的发送队列，发送方放入序列化后的消息，Writer协程取出后写到连接上
+type sendQueue struct {
+	mtx    sync.Mutex
+	limits SendQueueLimits
+	items  [][]byte
+	bytes  int
+	// 超过高水位，尚未降到低水位以下
+	high   bool
+	closed bool
+	// 有新消息时通知Writer
+	ready chan struct{}
+	// 有空间时关闭并替换，唤醒所有等待的发送方
+	space chan struct{}
+	// 等待space的发送方数量，没有等待者时不替换space
+	waiters int
+}
+
+func newSendQueue(limits SendQueueLimits) *sendQueue {
+	return &sendQueue{
+		limits: limits,
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}),
+	}
+}
+
+// fits 能否再放入n字节的消息，调用方需持有q.mtx
+func (q *sendQueue) fits(n int) bool {
+	if len(q.items) == 0 {
+		return true
+	}
+	if q.limits.MaxMsgs > 0 && len(q.items) >= q.limits.MaxMsgs {
+		return false
+	}
+	return q.limits.MaxBytes <= 0 || q.bytes+n <= q.limits.MaxBytes
+}
+
+// push 放入一条消息。队列已满时按策略处理，wait为false时 OverflowBlock 不等待。
+// highWater表示这次放入使队列达到了高水位，disconnect表示应当按 OverflowDisconnect 关闭会话
+func (q *sendQueue) push(data []byte, wait, detached bool) (highWater, disconnect bool, err error) {
+	var deadline <-chan time.Time
+	q.mtx.Lock()
+	for !q.fits(len(data)) {
+		if q.closed {
+			q.mtx.Unlock()
+			return false, false, ErrSessionClosed
+		}
+		policy := q.limits.Policy
+		if detached && policy != OverflowDropOldest {
+			// 等待恢复期间没有Writer，等待和断开都没有意义
+			err = q.fullError(ErrResumeBufferFull)
+			q.mtx.Unlock()
+			return false, false, err
+		}
+		switch {
+		case policy == OverflowDropOldest:
+			q.bytes -= len(q.items[0])
+			q.items[0] = nil
+			q.items = q.items[1:]
+			continue
+		case policy == OverflowBlock && wait:
+			if deadline == nil && q.limits.BlockTimeout > 0 {
+				timer := time.NewTimer(q.limits.BlockTimeout)
+				defer timer.Stop()
+				deadline = timer.C
+			}
+			space := q.space
+			q.waiters++
+			q.mtx.Unlock()
+			select {
+			case <-space:
+			case <-deadline:
+				q.mtx.Lock()
+				q.waiters--
+				if q.fits(len(data)) {
+					continue
+				}
+				err = q.fullError(ErrSendTimeout)
+				q.mtx.Unlock()
+				return false, false, err
+			}
+			q.mtx.Lock()
+			q.waiters--
+			continue
+		}
+		err = q.fullError(ErrSendQueueFull)
+		q.mtx.Unlock()
+		return false, policy == OverflowDisconnect, err
+	}
+	if q.closed {
+		q.mtx.Unlock()
+		return false, false, ErrSessionClosed
+	}
+	q.items = append(q.items, data)
+	q.bytes += len(data)
+	if !q.high && q.limits.HighWater > 0 && q.bytes >= q.limits.HighWater {
+		q.high = true
+		highWater = true
+	}
+	q.mtx.Unlock()
+	q.signal()
+	return highWater, false, nil
+}
+
+// fullError 生成队列已满的错误，调用方需持有q.mtx
+func (q *sendQueue) fullError(err error) *SendQueueError {
+	return &SendQueueError{Policy: q.limits.Policy, Msgs: len(q.items), Bytes: q.bytes, Err: err}
+}
+
+// pop 取出最早的一条消息，lowWater表示这次取出使队列从高水位降到了低水位以下
+func (q *sendQueue) pop() (data []byte, lowWater, ok bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.items) == 0 {
+		return nil, false, false
+	}
+	data = q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	q.bytes -= len(data)
+	if q.high && (q.bytes < q.limits.LowWater || len(q.items) == 0) {
+		q.high = false
+		lowWater = true
+	}
+	if !q.closed && q.waiters > 0 {
+		// 唤醒等待空间的发送方
+		close(q.space)
+		q.space = make(chan struct{})
+	}
+	return data, lowWater, true
+}
+
+// signal 通知Writer有待发送的消息
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// close 唤醒所有等待的发送方，之后不再接受新消息
+func (q *sendQueue) close() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.space)
+}
+
+// len 返回排队的消息数和字节数
+func (q *sendQueue) len() (msgs, bytes int) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return len(q.items), q.bytes
+}
+
+// aboveHighWater 是否处于高水位
+func (q *sendQueue) aboveHighWater() bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return q.high
+}
@@ -0,0 +1,159 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_SendQueue(t *testing.T) {
+	// 每条消息序列化后100字节
+	body := make([]byte, 90)
+	msg := func(serial int) *message.SeqedTLVMsg {
+		return message.NewSeqedTLVMsg(uint32(serial), 2, body)
+	}
+
+	t.Run("Limits", func(t *testing.T) {
+		// 没有Writer协程，消息只进不出
+		s := NewSession(nil, nil, WithSendQueue(SendQueueLimits{MaxMsgs: 10, MaxBytes: 300}))
+		for i := range 3 {
+			require.NoError(t, s.TrySendMsg(msg(i)))
+		}
+		err := s.TrySendMsg(msg(3))
+		assert.ErrorIs(t, err, ErrSendQueueFull)
+		var qerr *SendQueueError
+		require.ErrorAs(t, err, &qerr)
+		assert.Equal(t, 3, qerr.Msgs)
+		assert.Equal(t, 300, qerr.Bytes)
+
+		// 队列为空时总能放入一条消息
+		s = NewSession(nil, nil, WithSendQueue(SendQueueLimits{MaxBytes: 10}))
+		assert.NoError(t, s.SendMsg(msg(0)))
+	})
+
+	t.Run("DropNewest", func(t *testing.T) {
+		sent := 0
+		s := NewSession(nil, nil, WithSendQueue(SendQueueLimits{MaxMsgs: 2, Policy: OverflowDropNewest}),
+			WithHooks(Hooks{OnSend: func(common.ISession, message.IPacket) { sent++ }}))
+		require.NoError(t, s.SendMsg(msg(0)))
+		require.NoError(t, s.SendMsg(msg(1)))
+		assert.ErrorIs(t, s.SendMsg(msg(2)), ErrSendQueueFull)
+		assert.ErrorIs(t, s.TrySendMsg(msg(3)), ErrSendQueueFull)
+		// 被丢弃的消息不调用 OnSend
+		assert.Equal(t, 2, sent)
+		msgs, bytes := s.SendQueueLen()
+		assert.Equal(t, 2, msgs)
+		assert.Equal(t, 200, bytes)
+	})
+
+	t.Run("DropOldest", func(t *testing.T) {
+		s := NewSession(nil, nil, WithSendQueue(SendQueueLimits{MaxMsgs: 3, Policy: OverflowDropOldest}))
+		for i := range 5 {
+			require.NoError(t, s.SendMsg(msg(i)))
+		}
+		// 没有等待的发送方时，取出消息不替换space
+		space := s.sendQueue.space
+		for want := 2; want < 5; want++ {
+			data, _, ok := s.sendQueue.pop()
+			require.True(t, ok)
+			got := &message.SeqedTLVMsg{}
+			require.NoError(t, message.Unmarshal(data, got, true))
+			assert.Equal(t, uint32(want), got.Serial())
+		}
+		assert.Equal(t, space, s.sendQueue.space)
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		// 对端不读取，Writer阻塞在第一条消息上
+		s, _, reasons := openTimeoutSession(t, nil, func(s *Session) {
+			WithSendQueue(SendQueueLimits{MaxMsgs: 1, BlockTimeout: 50 * time.Millisecond})(s)
+		})
+		var err error
+		start := time.Now()
+		for i := range 3 {
+			if err = s.SendMsg(msg(i)); err != nil {
+				break
+			}
+		}
+		assert.ErrorIs(t, err, ErrSendTimeout)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+		assert.Empty(t, reasons)
+
+		// 会话关闭时唤醒等待的发送方
+		s.sendQueue.mtx.Lock()
+		s.sendQueue.limits.BlockTimeout = 0
+		s.sendQueue.mtx.Unlock()
+		errCh := make(chan error, 1)
+		go func() { errCh <- s.SendMsg(msg(3)) }()
+		time.Sleep(20 * time.Millisecond)
+		s.Close(common.CloseNormal)
+		select {
+		case err := <-errCh:
+			assert.ErrorIs(t, err, ErrSessionClosed)
+		case <-time.After(time.Second):
+			t.Fatal("SendMsg still blocked after close")
+		}
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		s, peer, reasons := openTimeoutSession(t, nil, func(s *Session) {
+			WithSendQueue(SendQueueLimits{MaxMsgs: 2, Policy: OverflowDisconnect})(s)
+		})
+		var err error
+		for i := range 10 {
+			if err = s.SendMsg(msg(i)); err != nil {
+				break
+			}
+		}
+		assert.ErrorIs(t, err, ErrSendQueueFull)
+		assert.ErrorIs(t, s.SendMsg(msg(0)), ErrSessionClosed)
+		// 对端恢复读取后收到排队的消息和关闭消息
+		for {
+			m := recv(t, peer)
+			if m.Tag() == uint16(job.CloseTag) {
+				reason, _, err := job.DecodeClose(m.Body())
+				require.NoError(t, err)
+				assert.Equal(t, common.CloseSlowConsumer, reason)
+				break
+			}
+		}
+		expectClose(t, reasons, common.CloseSlowConsumer, time.Second)
+	})
+
+	t.Run("Watermarks", func(t *testing.T) {
+		high := make(chan struct{}, 1)
+		low := make(chan struct{}, 1)
+		s, peer, _ := openTimeoutSession(t, nil, func(s *Session) {
+			WithSendQueue(SendQueueLimits{MaxBytes: 1000, HighWater: 500, LowWater: 200})(s)
+			OnHighWater(func(common.ISession) { high <- struct{}{} })(s)
+			OnLowWater(func(common.ISession) { low <- struct{}{} })(s)
+		})
+		assert.True(t, s.Writable())
+		// Writer最多取走一条，队列中至少还有600字节
+		for i := range 7 {
+			require.NoError(t, s.SendMsg(msg(i)))
+		}
+		select {
+		case <-high:
+		case <-time.After(time.Second):
+			t.Fatal("high water not reported")
+		}
+		assert.False(t, s.Writable())
+
+		for i := range 7 {
+			assert.Equal(t, uint32(i), recv(t, peer).Serial())
+		}
+		select {
+		case <-low:
+		case <-time.After(time.Second):
+			t.Fatal("low water not reported")
+		}
+		assert.True(t, s.Writable())
+	})
+}
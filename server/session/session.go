@@ -4,8 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/auth"
 	"github.com/Meha555/pulse/server/common"
 	"github.com/Meha555/pulse/server/job"
 
@@ -20,6 +24,9 @@ import (
 
 var logger *tinylog.Logger
 
+// ErrPacketTooLarge 收到的消息长度超过 WithMaxPacketSize 设置的限制
+var ErrPacketTooLarge = errors.New("packet too large")
+
 func init() {
 	var err error
 	logger, err = tinylog.NewStdLogger(tinylog.LevelInfo, "session", "[%t] [%c %l] [%f:%C:%L:%g] %m", false, tinylog.Lcolored)
@@ -31,42 +38,111 @@ func init() {
 // Session
 // 将裸的TCP socket包装，将具体的业务与连接绑定
 type Session struct {
-	// 当前连接的socket TCP套接字
-	conn *net.TCPConn
+	// 当前连接的socket TCP套接字，开启TLS时为 *tls.Conn
+	conn net.Conn
 	// 当前连接的ID 也可以称作为SessionID，ID全局唯一
 	sessionID uuid.UUID
 	// 当前连接的关闭状态
 	isClosed atomic.Bool
-	// 保活心跳次数
-	heartbeat uint
-
-	// 工作协程池
-	workerPool *job.WorkerPool
-
-	// 用于读写协程(Reader/Writer)之间的通信（用于实现读写业务分离）
-	msgCh chan []byte
+	// 连续没有收到心跳的次数
+	heartbeat atomic.Uint32
+	// 收到任何消息都视为对端存活
+	heartBeatAnyTraffic bool
+	// 心跳统计，RTT和时钟偏差为纳秒，最近一次收到心跳的时间为unix纳秒
+	rtt       atomic.Int64
+	clockSkew atomic.Int64
+	lastSeen  atomic.Int64
+	// 等待Pong的 Ping，<序列号, 接收RTT的chan>
+	pingSerial atomic.Uint32
+	pings      map[uint32]chan time.Duration
+	pingMtx    sync.Mutex
+	// 时间轮上的心跳定时器，由会话管理器开启和停止
+	heartBeatTimer atomic.Pointer[utils.Timer]
+	// 心跳定时器通知Writer发送心跳，发送消息的钩子不在时间轮的协程中执行
+	heartBeatCh chan struct{}
+
+	// 将请求交给业务协程处理
+	dispatcher job.Dispatcher
+
+	// 用于读写协程(Reader/Writer)之间的通信（用于实现读写业务分离），按消息数和字节数限制
+	sendQueue *sendQueue
 	// 通知该连接已经停止
 	exitCh chan struct{}
+	// 优雅关闭时的关闭消息，Writer发出已排队的消息和关闭消息后关闭会话
+	closingCh chan closeFrame
+	// 已经开始优雅关闭，不再接受新消息
+	closing atomic.Bool
+	// 优雅关闭超时后强制关闭的定时器
+	closeTimer *utils.Timer
 
 	hookStub hooks
+
+	// 认证器，为nil表示不需要认证
+	authenticator auth.Authenticator
+	// 认证通过的对端身份
+	principal atomic.Pointer[common.Principal]
+	// 会话的属性
+	attrs *attributes
+
+	// 读超时，超过该时间没有收到任何数据则关闭会话
+	readTimeout time.Duration
+	// 写超时，超过该时间没能发出消息则关闭会话
+	writeTimeout time.Duration
+	// 空闲超时，超过该时间没有业务消息则关闭会话
+	idleTimeout time.Duration
+	// 收到的消息体的最大长度，0表示不限制
+	maxPacketSize uint32
+	// 最近一次业务消息的时间（unix纳秒）
+	lastActive atomic.Int64
+	// 空闲检查的定时器
+	idleTimer *utils.Timer
+	// 会话关闭的原因，common.CloseReason
+	closeReason atomic.Int32
+
+	// 以下用于会话恢复，仅在会话管理器开启了会话恢复时使用
+	// 签发恢复令牌的会话管理器
+	mgr *SessionMgr
+	// 客户端重连时用于恢复该会话的令牌
+	resumeToken string
+	// 连接已断开，正在等待客户端恢复
+	detached atomic.Bool
+	// 当前连接的读写协程退出时关闭
+	connDone chan struct{}
+	// Writer协程退出后关闭
+	writerDone chan struct{}
+	// 等待恢复超时后关闭会话
+	graceTimer *utils.Timer
+	// 保护conn、connDone等在恢复时会被替换的状态
+	mtx sync.RWMutex
 }
 
-func NewSession(conn *net.TCPConn, workerPool *job.WorkerPool, hookOpts ...hookOpt) *Session {
+func NewSession(conn net.Conn, dispatcher job.Dispatcher, hookOpts ...hookOpt) *Session {
 	c := &Session{
-		conn:       conn,
-		sessionID:  uuid.New(),
-		isClosed:   atomic.Bool{},
-		heartbeat:  0,
-		workerPool: workerPool,
-		msgCh:      make(chan []byte, utils.Conf.Server.MaxMsgQueueSize), // 这里设置缓冲区大小为10，允许读写协程的处理速率有一定的差异
-		exitCh:     make(chan struct{}, 1),                               // 这里设置为 1，确保至少有一个缓冲区，防止写入时没人读导致阻塞，或者反之
+		conn:         conn,
+		sessionID:    uuid.New(),
+		isClosed:     atomic.Bool{},
+		dispatcher:   dispatcher,
+		sendQueue:    newSendQueue(defaultSendQueueLimits()), // 允许读写协程的处理速率有一定的差异
+		exitCh:       make(chan struct{}, 1),                 // 这里设置为 1，确保至少有一个缓冲区，防止写入时没人读导致阻塞，或者反之
+		closingCh:    make(chan closeFrame, 1),
+		heartBeatCh:  make(chan struct{}, 1),
+		attrs:        newAttributes(),
+		pings:        make(map[uint32]chan time.Duration),
+		readTimeout:  time.Duration(utils.Conf.Server.ConnTimeout) * time.Second,
+		writeTimeout: time.Duration(utils.Conf.Server.WriteTimeout) * time.Second,
+		idleTimeout:  time.Duration(utils.Conf.Server.IdleTimeout) * time.Second,
 		hookStub: hooks{
 			onOpen:     noOp,
-			onClose:    noOp,
-			beforeSend: noOp,
+			onClose:    noOpClose,
+			onAuth:     noOpAuth,
+			onError:    noOpError,
+			beforeSend: noOpMsg,
 			beforeRecv: noOp,
-			afterSend:  noOp,
-			afterRecv:  noOp,
+			afterSend:  noOpMsg,
+			afterRecv:  noOpMsg,
+
+			onHighWater: noOp,
+			onLowWater:  noOp,
 		},
 	}
 
@@ -77,13 +153,39 @@ func NewSession(conn *net.TCPConn, workerPool *job.WorkerPool, hookOpts ...hookO
 	return c
 }
 
+// WithMaxPacketSize 限制收到的消息体长度，超过时以 common.CloseProtocolError 关闭会话。默认不限制，Server 使用配置文件中的max_packet_size
+func WithMaxPacketSize(limit uint32) hookOpt {
+	return func(c *Session) {
+		c.maxPacketSize = limit
+	}
+}
+
 func (c *Session) Open() error {
-	// 启动IO协程负责该连接的读写操作
-	go c.Reader()
-	go c.Writer()
+	if c.authenticator != nil {
+		// 认证通过之前不启动读写协程，不会路由任何请求
+		if err := c.authenticate(); err != nil {
+			logger.Warnf("Session %s authenticate failed: %v", c.ID(), err)
+			c.hookStub.onError(c, err)
+			c.Close(common.CloseAuthFailed)
+			return err
+		}
+	}
+	if c.mgr != nil {
+		// 在读写协程启动前同步下发握手消息，保证它是客户端收到的第一条消息
+		if err := c.handshake(); err != nil {
+			logger.Errorf("Session %s send handshake error: %v", c.ID(), err)
+		}
+	}
 
+	// 在读协程启动前调用，保证先于收到的第一条消息
 	c.hookStub.onOpen(c)
 
+	// 启动IO协程负责该连接的读写操作
+	c.mtx.Lock()
+	c.start()
+	c.startIdleTimer()
+	c.mtx.Unlock()
+
 	// 等待 Stop() 方法通知退出
 	for range c.exitCh {
 		return nil
@@ -91,17 +193,38 @@ func (c *Session) Open() error {
 	return nil
 }
 
-func (c *Session) Close() {
-	if c.isClosed.Load() {
+func (c *Session) Close(reason common.CloseReason) {
+	c.shutdown(reason, true)
+}
+
+// shutdown 停止会话的工作，closeConn为false时不关闭连接（连接已移交给其它会话）
+func (c *Session) shutdown(reason common.CloseReason, closeConn bool) {
+	if !c.isClosed.CompareAndSwap(false, true) {
 		return
 	}
-	c.isClosed.Store(true)
+	c.closeReason.Store(int32(reason))
 
-	c.hookStub.onClose(c)
+	c.hookStub.onClose(c, reason)
+	// 关闭钩子中仍然可以读取属性
+	c.attrs.close()
 
-	c.conn.Close()
+	c.mtx.Lock()
+	if c.graceTimer != nil {
+		c.graceTimer.Stop()
+	}
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	if c.closeTimer != nil {
+		c.closeTimer.Stop()
+	}
+	c.mtx.Unlock()
+	if closeConn {
+		c.Conn().Close()
+	}
+	// 唤醒阻塞在发送队列上的发送方
+	c.sendQueue.close()
 	c.exitCh <- struct{}{} // 通知 Open() 方法退出
-	close(c.msgCh)
 	close(c.exitCh)
 }
 
@@ -110,57 +233,75 @@ func (c *Session) ID() uuid.UUID {
 }
 
 func (c *Session) Conn() net.Conn {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
 	return c.conn
 }
 
-func (c *Session) UpdateHeartBeat() {
-	c.heartbeat = 0
-}
-
-func (c *Session) HeartBeat() uint {
-	return c.heartbeat
-}
-
 func (c *Session) Send(data []byte) (int, error) {
 	if c.isClosed.Load() {
-		return 0, errors.New("connection is closed")
+		return 0, ErrSessionClosed
 	}
-	return c.conn.Write(data)
+	return c.Conn().Write(data)
 }
 
 func (c *Session) Recv(data []byte) (int, error) {
 	if c.isClosed.Load() {
-		return 0, errors.New("connection is closed")
+		return 0, ErrSessionClosed
+	}
+	return c.Conn().Read(data)
+}
+
+// writeDirect 不经过Writer协程，直接向连接写出消息，仅用于读写协程启动之前
+func (c *Session) writeDirect(msg message.IPacket) error {
+	data, err := message.Marshal(msg)
+	if err != nil {
+		return err
 	}
-	return c.conn.Read(data)
+	_, err = c.Send(data)
+	return err
 }
 
+// SendMsg 将消息放入发送队列，队列已满时按 OverflowPolicy 处理，没能放入时返回 *SendQueueError
 func (c *Session) SendMsg(msg message.IPacket) error {
-	if c.isClosed.Load() {
-		return errors.New("connection is closed")
+	if c.isClosed.Load() || c.closing.Load() {
+		return ErrSessionClosed
 	}
-	c.hookStub.beforeSend(c)
-	defer c.hookStub.afterSend(c)
+	c.hookStub.beforeSend(c, msg)
 	data, err := message.Marshal(msg)
 	if err != nil {
 		return err
 	}
+	c.touchIfApp(msg)
 	// return c.conn.Write(data)
 	// 提交给让Writer协程异步发送，这样不会因为底层TCP发送缓冲区满而导致这里阻塞
 	// 如果发送有错误，则由Writer协程处理，这里直接返回
-	c.msgCh <- data
+	// 等待恢复期间没有Writer协程，消息暂存在发送队列中，恢复后再发出
+	if err := c.enqueue(data, true); err != nil {
+		return err
+	}
+	c.hookStub.afterSend(c, msg)
 	return nil
 }
 
 // NOTE 这种接口作为传出参数，不用指针可以实现传出修改
 func (c *Session) RecvMsg(msg message.IPacket) error {
 	if c.isClosed.Load() {
-		return errors.New("connection is closed")
+		return ErrSessionClosed
 	}
 	c.hookStub.beforeRecv(c)
-	defer c.hookStub.afterRecv(c)
+	if err := c.readMsg(msg); err != nil {
+		return err
+	}
+	c.hookStub.afterRecv(c, msg)
+	return nil
+}
+
+// readMsg 从连接中读取一条完整的消息
+func (c *Session) readMsg(msg message.IPacket) error {
+	conn := c.Conn()
 	headerData := make([]byte, msg.HeaderLen())
-	if _, err := io.ReadFull(c.conn, headerData); err != nil {
+	if _, err := io.ReadFull(conn, headerData); err != nil {
 		return fmt.Errorf("read header error: %w", err)
 	}
 	if err := message.Unmarshal(headerData, msg, false); err != nil {
@@ -170,8 +311,11 @@ func (c *Session) RecvMsg(msg message.IPacket) error {
 	if msg.BodyLen() <= 0 {
 		return nil
 	}
+	if limit := c.maxPacketSize; limit > 0 && msg.BodyLen() > limit {
+		return fmt.Errorf("%w: %d > %d", ErrPacketTooLarge, msg.BodyLen(), limit)
+	}
 	bodyData := make([]byte, msg.BodyLen())
-	if _, err := io.ReadFull(c.conn, bodyData); err != nil {
+	if _, err := io.ReadFull(conn, bodyData); err != nil {
 		return fmt.Errorf("read body error: %w", err)
 	}
 	if err := message.UmarshalBodyOnly(bodyData, int(msg.BodyLen()), msg); err != nil {
@@ -184,27 +328,103 @@ func (c *Session) ExitChan() <-chan struct{} {
 	return c.exitCh
 }
 
+func (c *Session) MaxPacketSize() uint32 {
+	return c.maxPacketSize
+}
+
+func (c *Session) Principal() *common.Principal {
+	return c.principal.Load()
+}
+
+func (c *Session) Attrs() common.IAttributes {
+	return c.attrs
+}
+
 // 确保 Connection 实现 IConenction 方法
 var _ common.ISession = (*Session)(nil)
 
+// start 为当前连接启动读写协程，调用方需持有c.mtx
+func (c *Session) start() {
+	go c.Reader()
+	c.startWriter()
+}
+
+// startWriter 启动Writer协程，调用方需持有c.mtx
+func (c *Session) startWriter() {
+	c.connDone = make(chan struct{})
+	c.writerDone = make(chan struct{})
+	go c.Writer()
+	// 等待恢复期间排队的消息
+	c.sendQueue.signal()
+}
+
+// stopWriter 停止Writer协程并等待它退出，之后不会再向连接写入
+func (c *Session) stopWriter() {
+	c.mtx.Lock()
+	close(c.connDone)
+	writerDone := c.writerDone
+	c.mtx.Unlock()
+	<-writerDone
+}
+
 // Reader 是用于读取客户端数据的 Goroutine
 // 会需要与主协程通过chan通信
 func (c *Session) Reader() {
 	logger.Debug("Reader Goroutine is running")
 	defer logger.Debugf(c.Conn().RemoteAddr().String(), " Reader Goroutine exit!")
-	defer c.Close() // 确保连接能被关闭
 
 	for {
 		msg := &message.SeqedTLVMsg{}
+		if c.readTimeout > 0 {
+			c.Conn().SetReadDeadline(time.Now().Add(c.readTimeout))
+		}
 		if err := c.RecvMsg(msg); err != nil {
-			logger.Errorf("RecvMsg error: %v", err)
-			c.Close()
+			reason := closeReasonOf(err)
+			if reason != common.ClosePeerClosed && !c.isClosed.Load() {
+				c.hookStub.onError(c, err)
+			}
+			if reason == common.CloseProtocolError {
+				// 违反协议的连接不能恢复。连接仍然可写，告知对端违反了什么协议
+				logger.Errorf("RecvMsg error (%s): %v", reason, err)
+				if c.Disconnect(reason, err.Error()) != nil {
+					c.Close(reason)
+				}
+				return
+			}
+			if c.detach() {
+				logger.Warnf("Session %s detached, waiting for resume: %v", c.ID(), err)
+				return
+			}
+			logger.Errorf("RecvMsg error (%s): %v", reason, err)
+			c.Close(reason)
 			return
 		}
+		if !job.IsSystemTag(msg.Tag()) {
+			c.touch()
+		}
+		if c.heartBeatAnyTraffic {
+			c.UpdateHeartBeat()
+		}
+		if msg.Tag() == job.HeartBeatTag {
+			// 心跳不经过协程池，避免排队影响RTT的测量
+			c.onHeartBeat(msg)
+			continue
+		}
+		if msg.Tag() == job.PongTag {
+			c.onPong(msg)
+			continue
+		}
+		if msg.Tag() == job.ResumeTag && c.mgr != nil {
+			if c.resume(msg) {
+				// 连接已移交给被恢复的会话
+				return
+			}
+			continue
+		}
 		// 封装请求数据
 		req := GetRequest(c, msg)
 		// 提交给协程池来处理业务
-		c.workerPool.Post(req)
+		c.dispatcher.Post(req)
 	}
 }
 
@@ -213,13 +433,45 @@ func (c *Session) Reader() {
 func (c *Session) Writer() {
 	logger.Debug("Writer Goroutine is running")
 	defer logger.Debugf(c.Conn().RemoteAddr().String(), " Writer Goroutine exit!")
+	c.mtx.RLock()
+	connDone, writerDone := c.connDone, c.writerDone
+	c.mtx.RUnlock()
+	defer close(writerDone)
 	for {
 		select {
-		case data := <-c.msgCh: // 从msgCh中读取数据
-			if _, err := c.Send(data); err != nil {
-				logger.Errorf("Send error: %v", err)
-				continue
+		case <-c.sendQueue.ready: // 发出发送队列中的所有消息
+			for {
+				data, lowWater, ok := c.sendQueue.pop()
+				if !ok {
+					break
+				}
+				if lowWater {
+					c.hookStub.onLowWater(c)
+				}
+				if c.writeTimeout > 0 {
+					c.Conn().SetWriteDeadline(time.Now().Add(c.writeTimeout))
+				}
+				if _, err := c.Send(data); err != nil {
+					if errors.Is(err, os.ErrDeadlineExceeded) {
+						// 对端不再读取，不能让Writer一直阻塞
+						logger.Warnf("Session %s write timeout, close it", c.ID())
+						c.Close(common.CloseWriteTimeout)
+						return
+					}
+					logger.Errorf("Send error: %v", err)
+					if !c.isClosed.Load() {
+						c.hookStub.onError(c, err)
+					}
+				}
 			}
+		case <-c.heartBeatCh: // 发送心跳，心跳消息放入发送队列后由上面的分支发出
+			c.sendHeartBeat()
+		case frame := <-c.closingCh: // 优雅关闭
+			c.flush(frame.data)
+			c.Close(frame.reason)
+			return
+		case <-connDone: // 连接断开，等待恢复
+			return
 		case <-c.exitCh: // 响应退出信号
 			return
 		}
@@ -4,9 +4,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/Meha555/pulse/core/message"
 	"github.com/Meha555/pulse/server/common"
-	"github.com/Meha555/pulse/server/job"
 	"github.com/Meha555/pulse/utils"
 
 	"github.com/google/uuid"
@@ -16,39 +14,43 @@ import (
 // 支持在添加连接时自动监听其 exitChan，并在 exitCh 关闭时自动删除连接
 type SessionMgr struct {
 	sessionMap map[uuid.UUID]common.ISession
+	// 会话恢复的等待时间，0表示不支持会话恢复
+	gracePeriod time.Duration
+	// <恢复令牌, 会话ID>
+	resumeTokens map[string]uuid.UUID
+	// 广播时发送队列已满的处理策略
+	slowConsumerPolicy SlowConsumerPolicy
+	// 属性索引，<属性key, <属性值, 会话ID集合>>
+	indexes map[string]map[any]map[uuid.UUID]struct{}
+	// 各会话已加入索引的属性，<会话ID, <属性key, 属性值>>
+	indexed map[uuid.UUID]map[string]any
+	// 业务key与会话的绑定，<key, 会话ID集合>
+	bindings map[string]map[uuid.UUID]struct{}
+	// 各会话绑定的key，<会话ID, key集合>
+	boundKeys map[uuid.UUID]map[string]struct{}
+	// 重复绑定的处理策略
+	duplicatePolicy DuplicatePolicy
 
-	// 用于心跳检查的定时器
-	heartBeatTicker *time.Ticker
+	// 心跳策略
+	heartBeatPolicy common.HeartBeatPolicy
 	mtx             sync.RWMutex
 	wg              sync.WaitGroup
 }
 
-func NewSessionMgr() *SessionMgr {
+func NewSessionMgr(opts ...SessionMgrOption) *SessionMgr {
 	c := &SessionMgr{
 		sessionMap:      make(map[uuid.UUID]common.ISession),
-		heartBeatTicker: time.NewTicker(time.Duration(utils.Conf.Server.HeartBeatTick) * time.Second),
+		gracePeriod:     time.Duration(utils.Conf.Server.ResumeGracePeriod) * time.Second,
+		resumeTokens:    make(map[string]uuid.UUID),
+		indexes:         make(map[string]map[any]map[uuid.UUID]struct{}),
+		indexed:         make(map[uuid.UUID]map[string]any),
+		bindings:        make(map[string]map[uuid.UUID]struct{}),
+		boundKeys:       make(map[uuid.UUID]map[string]struct{}),
+		heartBeatPolicy: defaultHeartBeatPolicy(),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
-
-	// 心跳检查
-	go func() {
-		for range c.heartBeatTicker.C {
-			c.mtx.Lock()
-			// 时刻到，检查心跳情况
-			for _, session := range c.sessionMap {
-				go func(session common.ISession) {
-					if session.HeartBeat() < 5 {
-						session.(*Session).heartbeat++
-						session.(*Session).SendMsg(message.NewSeqedTLVMsg(0, job.HeartBeatTag, nil))
-					} else {
-						// 说明已经5 * utils.Conf.Server.HeartBeatTick秒未收到该客户端的心跳包，判定该客户端已经掉线
-						logger.Warnf("Conn %s is timeout, maybe offline", session.ID())
-						c.Del(session.ID())
-					}
-				}(session)
-			}
-			c.mtx.Unlock()
-		}
-	}()
 
 	return c
 }
@@ -60,6 +62,11 @@ func (c *SessionMgr) Add(session common.ISession) {
 		return
 	}
 	c.sessionMap[session.ID()] = session
+	if s, ok := session.(*Session); ok && c.gracePeriod > 0 {
+		c.enableResume(s)
+	}
+	c.watchAttrs(session)
+	c.startHeartBeat(session)
 
 	// Start a goroutine to listen on the exitCh
 	c.wg.Add(1)
@@ -72,10 +79,28 @@ func (c *SessionMgr) Add(session common.ISession) {
 
 func (c *SessionMgr) Del(sessionID uuid.UUID) {
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
-	if session, exists := c.sessionMap[sessionID]; exists {
-		session.Close()
-		delete(c.sessionMap, sessionID)
+	session, exists := c.sessionMap[sessionID]
+	if exists {
+		c.remove(session)
+	}
+	c.mtx.Unlock()
+	// 在锁外关闭，关闭钩子中可以访问连接管理器和会话属性
+	if exists {
+		session.Close(common.CloseNormal)
+	}
+}
+
+// remove 从管理器中移除连接，调用方需持有c.mtx
+func (c *SessionMgr) remove(session common.ISession) {
+	delete(c.sessionMap, session.ID())
+	c.unindex(session.ID())
+	c.unbindAll(session.ID())
+	if s, ok := session.(*Session); ok {
+		s.stopHeartBeat()
+		s.attrs.setOnChange(nil)
+		if s.resumeToken != "" {
+			delete(c.resumeTokens, s.resumeToken)
+		}
 	}
 }
 
@@ -93,13 +118,21 @@ func (c *SessionMgr) Count() uint {
 
 func (c *SessionMgr) Clear() {
 	c.mtx.Lock()
-	for sessionID := range c.sessionMap {
-		if session, exists := c.sessionMap[sessionID]; exists {
-			session.Close()
-			delete(c.sessionMap, sessionID)
-		}
+	sessions := make([]common.ISession, 0, len(c.sessionMap))
+	for _, session := range c.sessionMap {
+		sessions = append(sessions, session)
+		c.remove(session)
 	}
 	c.mtx.Unlock()
+	for _, session := range sessions {
+		// 通知客户端服务端停机，客户端据此决定是否重连
+		if s, ok := session.(*Session); ok && s.Disconnect(common.CloseShutdown, "server shutdown") == nil {
+			continue
+		}
+		session.Close(common.CloseShutdown)
+	}
 	// Wait for all goroutines to finish
 	c.wg.Wait()
 }
+
+var _ common.ISessionMgr = (*SessionMgr)(nil)
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+)
+
+// CloseReason 返回会话关闭的原因，会话未关闭时为 common.CloseNone
+func (c *Session) CloseReason() common.CloseReason {
+	return common.CloseReason(c.closeReason.Load())
+}
+
+// closeReasonOf 根据读写错误判断关闭原因
+func closeReasonOf(err error) common.CloseReason {
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return common.CloseReadTimeout
+	case errors.Is(err, ErrPacketTooLarge):
+		return common.CloseProtocolError
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		return common.ClosePeerClosed
+	default:
+		return common.CloseError
+	}
+}
+
+// touch 记录最近一次业务消息的时间
+func (c *Session) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// touchIfApp 发出的是业务消息时记录活跃时间
+func (c *Session) touchIfApp(msg message.IPacket) {
+	if m, ok := msg.(message.ITLVMsg); ok && !job.IsSystemTag(m.Tag()) {
+		c.touch()
+	}
+}
+
+// startIdleTimer 开启空闲检查，调用方需持有c.mtx
+func (c *Session) startIdleTimer() {
+	if c.idleTimeout <= 0 {
+		return
+	}
+	c.touch()
+	c.idleTimer = utils.AfterFunc(c.idleTimeout, c.checkIdle)
+}
+
+// checkIdle 空闲超时则关闭会话，否则在剩余时间后再次检查。在时间轮的协程中执行，不能阻塞
+func (c *Session) checkIdle() {
+	if c.isClosed.Load() {
+		return
+	}
+	idle := time.Since(time.Unix(0, c.lastActive.Load()))
+	// 等待恢复期间由恢复超时负责关闭
+	if idle >= c.idleTimeout && !c.detached.Load() {
+		logger.Warnf("Session %s is idle for %v, close it", c.ID(), idle)
+		go c.Disconnect(common.CloseIdleTimeout, fmt.Sprintf("idle for %v", idle.Truncate(time.Millisecond)))
+		return
+	}
+	wait := c.idleTimeout - idle
+	if wait <= 0 {
+		wait = c.idleTimeout
+	}
+	c.mtx.Lock()
+	c.idleTimer.Reset(wait)
+	c.mtx.Unlock()
+}
@@ -0,0 +1,171 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Meha555/pulse/core/message"
+	"github.com/Meha555/pulse/server/common"
+	"github.com/Meha555/pulse/server/job"
+	"github.com/Meha555/pulse/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openTimeoutSession 启动会话，set用于在启动前设置超时，mgr不为nil时将会话加入mgr。返回对端连接和记录关闭原因的chan
+func openTimeoutSession(t *testing.T, mgr *SessionMgr, set func(s *Session)) (*Session, net.Conn, chan common.CloseReason) {
+	router := job.NewJobRouter()
+	require.NoError(t, router.Register(1, &sessionIDJob{}))
+	pool := job.NewWorkerPool(1, utils.NewBlockingQueue[common.IRequest](1), router)
+	// 不停止协程池：会话关闭时Reader可能仍在提交请求
+	pool.Start()
+
+	reasons := make(chan common.CloseReason, 1)
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { peer.Close() })
+	s := NewSession(conn, pool, OnClose(func(session common.ISession, reason common.CloseReason) {
+		reasons <- reason
+	}))
+	set(s)
+	if mgr != nil {
+		mgr.Add(s)
+	}
+	go s.Open()
+	t.Cleanup(func() { s.Close(common.CloseNormal) })
+	return s, peer, reasons
+}
+
+func expectClose(t *testing.T, reasons chan common.CloseReason, want common.CloseReason, within time.Duration) {
+	t.Helper()
+	select {
+	case reason := <-reasons:
+		assert.Equal(t, want, reason, "got %s", reason)
+	case <-time.After(within):
+		t.Fatalf("session not closed with %s", want)
+	}
+}
+
+func TestSession_Timeout(t *testing.T) {
+	t.Run("Read", func(t *testing.T) {
+		_, _, reasons := openTimeoutSession(t, nil, func(s *Session) {
+			s.readTimeout = 50 * time.Millisecond
+		})
+		expectClose(t, reasons, common.CloseReadTimeout, time.Second)
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		// 对端不读取，Writer阻塞在写上直到超时
+		s, _, reasons := openTimeoutSession(t, nil, func(s *Session) {
+			s.readTimeout = 0
+			s.writeTimeout = 50 * time.Millisecond
+		})
+		require.NoError(t, s.SendMsg(message.NewSeqedTLVMsg(0, 1, []byte("hello"))))
+		expectClose(t, reasons, common.CloseWriteTimeout, time.Second)
+	})
+
+	t.Run("Idle", func(t *testing.T) {
+		_, peer, reasons := openTimeoutSession(t, nil, func(s *Session) {
+			s.readTimeout = 0
+			s.idleTimeout = 150 * time.Millisecond
+		})
+		go func() {
+			// 丢弃服务端的回复
+			buf := make([]byte, 1024)
+			for {
+				if _, err := peer.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+		// 业务消息保持活跃，心跳不算
+		for range 3 {
+			send(t, peer, message.NewSeqedTLVMsg(0, 1, nil))
+			time.Sleep(80 * time.Millisecond)
+		}
+		select {
+		case reason := <-reasons:
+			t.Fatalf("session closed with %s while active", reason)
+		default:
+		}
+		go func() {
+			data, _ := message.Marshal(message.NewSeqedTLVMsg(0, job.HeartBeatTag, nil))
+			for {
+				if _, err := peer.Write(data); err != nil {
+					return
+				}
+				time.Sleep(40 * time.Millisecond)
+			}
+		}()
+		expectClose(t, reasons, common.CloseIdleTimeout, time.Second)
+	})
+
+	t.Run("PeerClosed", func(t *testing.T) {
+		_, peer, reasons := openTimeoutSession(t, nil, func(s *Session) {})
+		peer.Close()
+		expectClose(t, reasons, common.ClosePeerClosed, time.Second)
+	})
+
+	t.Run("Normal", func(t *testing.T) {
+		s, _, reasons := openTimeoutSession(t, nil, func(s *Session) {})
+		s.Close(common.CloseNormal)
+		expectClose(t, reasons, common.CloseNormal, time.Second)
+		assert.Equal(t, common.CloseNormal, s.CloseReason())
+	})
+}
+
+func TestSession_CloseFrame(t *testing.T) {
+	expectFrame := func(t *testing.T, peer net.Conn, want common.CloseReason) string {
+		t.Helper()
+		msg := recv(t, peer)
+		require.Equal(t, uint16(job.CloseTag), msg.Tag())
+		reason, text, err := job.DecodeClose(msg.Body())
+		require.NoError(t, err)
+		assert.Equal(t, want, reason)
+		return text
+	}
+
+	t.Run("ProtocolError", func(t *testing.T) {
+		_, peer, reasons := openTimeoutSession(t, nil, func(s *Session) {
+			WithMaxPacketSize(4096)(s)
+		})
+		data, err := message.Marshal(message.NewSeqedTLVMsg(0, 1, make([]byte, 4097)))
+		require.NoError(t, err)
+		// 服务端只读取包头，包体写不完
+		go peer.Write(data)
+		text := expectFrame(t, peer, common.CloseProtocolError)
+		assert.Contains(t, text, ErrPacketTooLarge.Error())
+		expectClose(t, reasons, common.CloseProtocolError, time.Second)
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		s, peer, reasons := openTimeoutSession(t, nil, func(s *Session) {})
+		require.NoError(t, s.Disconnect(common.CloseIdleTimeout, "go away"))
+		assert.Error(t, s.Disconnect(common.CloseKicked, "again"))
+		assert.Equal(t, "go away", expectFrame(t, peer, common.CloseIdleTimeout))
+		expectClose(t, reasons, common.CloseIdleTimeout, time.Second)
+		// 正常关闭后强制关闭的定时器已经停止
+		for range s.ExitChan() {
+		}
+		s.mtx.RLock()
+		defer s.mtx.RUnlock()
+		require.NotNil(t, s.closeTimer)
+		assert.False(t, s.closeTimer.Stop())
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		mgr := NewSessionMgr()
+		reasons := make(chan common.CloseReason, 1)
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		s := NewSession(conn, nil, OnClose(func(session common.ISession, reason common.CloseReason) {
+			reasons <- reason
+		}))
+		mgr.Add(s)
+		go s.Open()
+		go mgr.Clear()
+		expectFrame(t, peer, common.CloseShutdown)
+		expectClose(t, reasons, common.CloseShutdown, time.Second)
+	})
+}
@@ -16,12 +16,32 @@ type zServerConf struct {
 	Host              string `json:"host"`
 	Port              uint16 `json:"port"`
 	HeartBeatTick     uint   `json:"heartbeat_tick"`
-	ConnTimeout       uint   `json:"conn_timeout"`
+	ConnTimeout       uint   `json:"conn_timeout"` // 读超时（秒），超过该时间没有收到任何数据（包括心跳）则关闭连接，0表示不限制
 	MaxConnCount      uint   `json:"max_conn_count"`
 	MaxMsgQueueSize   uint   `json:"max_msg_queue_size"`
 	MaxPacketSize     uint32 `json:"max_packet_size"`
 	MaxWorkerPoolSize uint   `json:"max_worker_pool_size"`
 	RequestPoolMode   bool   `json:"request_pool_mode"`
+	// 连接断开后会话保留等待恢复的时间（秒），0表示不支持会话恢复
+	ResumeGracePeriod uint `json:"resume_grace_period"`
+	// 配置了认证时，连接建立后必须在该时间（秒）内完成认证，0表示不限制
+	AuthTimeout uint `json:"auth_timeout"`
+	// 写超时（秒），对端长时间不读取导致消息无法发出时关闭连接，0表示不限制
+	WriteTimeout uint `json:"write_timeout"`
+	// 空闲超时（秒），超过该时间没有业务消息（心跳等系统消息不计）则关闭连接，0表示不限制
+	IdleTimeout uint `json:"idle_timeout"`
+	// 主动发送心跳的一端："server"、"client"或"both"
+	HeartBeatInitiator string `json:"heartbeat_initiator"`
+	// 连续这么多次心跳检查没有收到客户端的心跳或回应时关闭连接，0表示不检查
+	HeartBeatMaxMissed uint `json:"heartbeat_max_missed"`
+	// 为true时收到任何消息都视为客户端存活，而不仅是心跳
+	HeartBeatAnyTraffic bool `json:"heartbeat_any_traffic"`
+	// 每个连接的发送队列最多排队的字节数，0表示只按 max_msg_queue_size 限制消息数
+	MaxSendQueueBytes uint `json:"max_send_queue_bytes"`
+	// 发送队列已满时的处理策略："block"、"drop_newest"、"drop_oldest"或"disconnect"
+	SendOverflowPolicy string `json:"send_overflow_policy"`
+	// 策略为"block"时发送方最长的等待时间（秒），0表示一直等待
+	SendBlockTimeout uint `json:"send_block_timeout"`
 }
 
 type zLogConf struct {
@@ -69,6 +89,18 @@ func init() {
 			MaxPacketSize:     4096,
 			MaxWorkerPoolSize: 10,
 			RequestPoolMode:   false,
+			ResumeGracePeriod: 0,
+			AuthTimeout:       10,
+			WriteTimeout:      10,
+			IdleTimeout:       0,
+
+			HeartBeatInitiator:  "server",
+			HeartBeatMaxMissed:  5,
+			HeartBeatAnyTraffic: false,
+
+			MaxSendQueueBytes:  1 << 20,
+			SendOverflowPolicy: "block",
+			SendBlockTimeout:   5,
 		},
 		Log: zLogConf{
 			Level:  2,
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"container/list"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/Meha555/go-tinylog"
+)
+
+var logger *tinylog.Logger
+
+func init() {
+	var err error
+	logger, err = tinylog.NewStdLogger(tinylog.LevelInfo, "utils", "[%t] [%c %l] [%f:%C:%L:%g] %m", false, tinylog.Lcolored)
+	if err != nil {
+		panic(err)
+	}
+}
+
+const (
+	// 默认时间轮的刻度和槽数，转一圈约10秒，更长的定时通过圈数实现
+	kDefaultWheelTick  = 10 * time.Millisecond
+	kDefaultWheelSlots = 1024
+)
+
+// TimingWheel 哈希时间轮
+// 定时器按到期的刻度散列到各个槽中，添加和删除都是O(1)，每个刻度只处理一个槽，
+// 大量连接的心跳、超时定时器不会在同一时刻集中唤醒。
+// 回调在时间轮的协程中依次执行，不能阻塞，耗时的操作应另起协程。
+// 没有定时器时时间轮的协程会退出，不会空转
+type TimingWheel struct {
+	tick  time.Duration
+	slots []*list.List
+	// 当前指向的槽
+	pos int
+	// 未到期的定时器个数
+	count int
+	// 时间轮的协程是否在运行
+	running bool
+	mtx     sync.Mutex
+}
+
+// Timer 时间轮上的定时器
+type Timer struct {
+	wheel *TimingWheel
+	fn    func()
+	// 还需要转过的圈数
+	rounds int
+	slot   int
+	// 所在槽中的节点，为nil表示没有在等待
+	elem *list.Element
+}
+
+// NewTimingWheel 创建时间轮，tick为刻度（定时的精度），slots为槽数
+func NewTimingWheel(tick time.Duration, slots int) *TimingWheel {
+	if tick <= 0 {
+		tick = kDefaultWheelTick
+	}
+	if slots <= 0 {
+		slots = kDefaultWheelSlots
+	}
+	w := &TimingWheel{
+		tick:  tick,
+		slots: make([]*list.List, slots),
+	}
+	for i := range w.slots {
+		w.slots[i] = list.New()
+	}
+	return w
+}
+
+var (
+	defaultWheel     *TimingWheel
+	defaultWheelOnce sync.Once
+)
+
+// DefaultTimingWheel 返回全局共享的时间轮
+func DefaultTimingWheel() *TimingWheel {
+	defaultWheelOnce.Do(func() {
+		defaultWheel = NewTimingWheel(kDefaultWheelTick, kDefaultWheelSlots)
+	})
+	return defaultWheel
+}
+
+// AfterFunc 在全局时间轮上添加定时器，d之后执行fn，用法同 time.AfterFunc
+func AfterFunc(d time.Duration, fn func()) *Timer {
+	return DefaultTimingWheel().AfterFunc(d, fn)
+}
+
+// AfterFunc 添加定时器，d之后在时间轮的协程中执行fn
+func (w *TimingWheel) AfterFunc(d time.Duration, fn func()) *Timer {
+	t := &Timer{wheel: w, fn: fn}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.add(t, d)
+	return t
+}
+
+// add 将定时器放入到期的槽，调用方需持有w.mtx
+func (w *TimingWheel) add(t *Timer, d time.Duration) {
+	ticks := int((d + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	t.slot = (w.pos + ticks) % len(w.slots)
+	t.rounds = (ticks - 1) / len(w.slots)
+	t.elem = w.slots[t.slot].PushBack(t)
+	w.count++
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+}
+
+// remove 将定时器移出槽，调用方需持有w.mtx
+func (w *TimingWheel) remove(t *Timer) bool {
+	if t.elem == nil {
+		return false
+	}
+	w.slots[t.slot].Remove(t.elem)
+	t.elem = nil
+	w.count--
+	return true
+}
+
+// run 按刻度转动时间轮，没有定时器时退出
+func (w *TimingWheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	next := time.Now().Add(w.tick)
+	for now := range ticker.C {
+		// ticker可能丢失刻度，按实际经过的时间补齐
+		for !now.Before(next) {
+			next = next.Add(w.tick)
+			if !w.advance() {
+				return
+			}
+		}
+	}
+}
+
+// advance 转过一个刻度并执行到期的定时器，没有定时器时返回false
+func (w *TimingWheel) advance() bool {
+	w.mtx.Lock()
+	w.pos = (w.pos + 1) % len(w.slots)
+	var expired []*Timer
+	slot := w.slots[w.pos]
+	for e := slot.Front(); e != nil; {
+		next := e.Next()
+		t := e.Value.(*Timer)
+		if t.rounds > 0 {
+			t.rounds--
+		} else {
+			w.remove(t)
+			expired = append(expired, t)
+		}
+		e = next
+	}
+	w.mtx.Unlock()
+
+	for _, t := range expired {
+		t.call()
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.count == 0 {
+		w.running = false
+		return false
+	}
+	return true
+}
+
+// call 执行回调，回调的panic不会影响时间轮
+func (t *Timer) call() {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("timing wheel callback panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	t.fn()
+}
+
+// Stop 停止定时器，定时器已经到期或已经停止时返回false
+func (t *Timer) Stop() bool {
+	t.wheel.mtx.Lock()
+	defer t.wheel.mtx.Unlock()
+	return t.wheel.remove(t)
+}
+
+// Reset 重新设置d之后执行，返回定时器在此之前是否在等待。可以在回调中调用
+func (t *Timer) Reset(d time.Duration) bool {
+	t.wheel.mtx.Lock()
+	defer t.wheel.mtx.Unlock()
+	active := t.wheel.remove(t)
+	t.wheel.add(t, d)
+	return active
+}
+
+// Len 返回未到期的定时器个数
+func (w *TimingWheel) Len() int {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.count
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimingWheel(t *testing.T) {
+	// 槽数很少，较长的定时需要转多圈
+	w := NewTimingWheel(5*time.Millisecond, 4)
+	start := time.Now()
+	var (
+		mtx   sync.Mutex
+		fired = make(map[string]time.Duration)
+		wg    sync.WaitGroup
+	)
+	after := func(name string, d time.Duration) *Timer {
+		wg.Add(1)
+		return w.AfterFunc(d, func() {
+			mtx.Lock()
+			fired[name] = time.Since(start)
+			mtx.Unlock()
+			wg.Done()
+		})
+	}
+	after("short", 10*time.Millisecond)
+	after("rounds", 60*time.Millisecond)
+	stopped := after("stopped", 20*time.Millisecond)
+	reset := after("reset", 10*time.Millisecond)
+
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+	wg.Done()
+	assert.True(t, reset.Reset(40*time.Millisecond))
+	assert.Equal(t, 3, w.Len())
+	wg.Wait()
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.NotContains(t, fired, "stopped")
+	assert.GreaterOrEqual(t, fired["short"], 10*time.Millisecond)
+	assert.GreaterOrEqual(t, fired["reset"], 40*time.Millisecond)
+	assert.GreaterOrEqual(t, fired["rounds"], 60*time.Millisecond)
+	assert.Less(t, fired["short"], fired["reset"])
+	assert.Less(t, fired["reset"], fired["rounds"])
+	assert.False(t, reset.Stop())
+
+	// 没有定时器后协程退出
+	assert.Eventually(t, func() bool {
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		return !w.running
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestTimingWheel_ResetInCallback(t *testing.T) {
+	w := NewTimingWheel(time.Millisecond, 16)
+	done := make(chan struct{})
+	n := 0
+	var (
+		timer *Timer
+		mtx   sync.Mutex
+	)
+	mtx.Lock()
+	timer = w.AfterFunc(time.Millisecond, func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		n++
+		if n < 3 {
+			timer.Reset(time.Millisecond)
+			return
+		}
+		close(done)
+	})
+	mtx.Unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("periodic timer not fired")
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 0, w.Len())
+}